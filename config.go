@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+// loadConfigFile applies the JSON object in path to the command-line flags. Keys are flag
+// names and values are strings, numbers, booleans or, for repeatable flags, arrays of them.
+// The entries of objectFlags may also be JSON objects, passed to the flag as JSON text.
+// Flags given on the command line take precedence. Unknown keys and invalid values are all
+// reported together in a single error.
+func loadConfigFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	var errs, unknown []string
+	for _, name := range slices.Sorted(maps.Keys(values)) {
+		f := flag.Lookup(name)
+		if f == nil || name == "config" {
+			unknown = append(unknown, name)
+			continue
+		}
+		if explicit[name] {
+			continue
+		}
+		for _, v := range configValues(values[name], slices.Contains(objectFlags, name)) {
+			if v.err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", name, v.err))
+				continue
+			}
+			if err := f.Value.Set(v.s); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: invalid value %q: %v", name, v.s, err))
+			}
+		}
+	}
+	if len(unknown) > 0 {
+		errs = append([]string{fmt.Sprintf("unknown keys: %s", strings.Join(unknown, ", "))}, errs...)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config file %s:\n  %s", path, strings.Join(errs, "\n  "))
+	}
+	return nil
+}
+
+// objectFlags lists the flags whose config file values may be JSON objects: the targets,
+// which carry their own policy as {"did": ..., "actions": [...], ...}.
+var objectFlags = []string{"target"}
+
+type configValue struct {
+	s   string
+	err error
+}
+
+// configValues converts a JSON value into the flag value strings it stands for: one for a
+// scalar, one per element for an array. Objects are kept as JSON text when objects is set.
+func configValues(raw json.RawMessage, objects bool) []configValue {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) > 0 && raw[0] == '[' {
+		var elems []json.RawMessage
+		if err := json.Unmarshal(raw, &elems); err != nil {
+			return []configValue{{err: err}}
+		}
+		var out []configValue
+		for _, e := range elems {
+			out = append(out, configElem(e, objects))
+		}
+		return out
+	}
+	return []configValue{configElem(raw, objects)}
+}
+
+// configElem converts a scalar, or an object when objects is set, into a flag value string.
+func configElem(raw json.RawMessage, objects bool) configValue {
+	if objects && len(raw) > 0 && raw[0] == '{' {
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, raw); err != nil {
+			return configValue{err: err}
+		}
+		return configValue{s: compact.String()}
+	}
+	return configScalar(raw)
+}
+
+func configScalar(raw json.RawMessage) configValue {
+	var v any
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return configValue{err: err}
+	}
+	switch v := v.(type) {
+	case string:
+		return configValue{s: v}
+	case bool, float64:
+		return configValue{s: string(bytes.TrimSpace(raw))}
+	default:
+		return configValue{err: errors.New("expected a string, number or boolean")}
+	}
+}
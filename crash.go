@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"runtime/debug"
+	"time"
+
+	"github.com/carlo-colombo/bs-reposter-liker/reposter"
+)
+
+// exitPanic is the exit status after a recovered panic (EX_SOFTWARE).
+const exitPanic = 70
+
+// recoverPanic, deferred in main, turns a panic into a structured error log with the stack,
+// records a crash marker in stateFile when set, and exits with exitPanic.
+// Panics in goroutines other than main's are not recovered.
+func recoverPanic(stateFile string) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	value := fmt.Sprint(v)
+	slog.Error("panic", "panic", value, "stack", string(debug.Stack()))
+	if stateFile != "" {
+		if err := reposter.RecordCrash(stateFile, value, time.Now()); err != nil {
+			slog.Error("Failed to record crash in state file", "stateFile", stateFile, "error", err)
+		}
+	}
+	os.Exit(exitPanic)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/carlo-colombo/bs-reposter-liker/reposter"
+)
+
+// TestRecoverPanic runs itself in a child process, where recoverPanic exits after a panic.
+func TestRecoverPanic(t *testing.T) {
+	if stateFile, ok := os.LookupEnv("RECOVER_PANIC_STATE_FILE"); ok {
+		defer recoverPanic(stateFile)
+		var post *struct{ uri string }
+		_ = post.uri // An unexpected nil, as in a malformed feed item.
+		return
+	}
+
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	cmd := exec.Command(os.Args[0], "-test.run=^TestRecoverPanic$")
+	cmd.Env = append(os.Environ(), "RECOVER_PANIC_STATE_FILE="+stateFile)
+	out, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != exitPanic {
+		t.Fatalf("Child process ended with %v, want exit status %d; output:\n%s", err, exitPanic, out)
+	}
+	for _, want := range []string{"nil pointer dereference", "crash_test.go"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("Panic log does not mention %q; output:\n%s", want, out)
+		}
+	}
+
+	state, found, err := reposter.LoadState(stateFile)
+	if err != nil || !found {
+		t.Fatalf("LoadState returned found %t and error %v, want the state file written", found, err)
+	}
+	if state.LastCrash == nil || !strings.Contains(state.LastCrash.Panic, "nil pointer dereference") || state.LastCrash.At.IsZero() {
+		t.Errorf("State file crash marker is %+v, want the panic recorded", state.LastCrash)
+	}
+}
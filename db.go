@@ -0,0 +1,134 @@
+//go:build sqlite
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/carlo-colombo/bs-reposter-liker/reposter"
+	_ "modernc.org/sqlite"
+)
+
+// historyMigrations are the schema changes of the --db database, applied in order on open.
+// The number applied is tracked in PRAGMA user_version: only append to this list.
+var historyMigrations = []string{
+	`CREATE TABLE actions (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		at         TEXT    NOT NULL,
+		target_did TEXT    NOT NULL,
+		post_uri   TEXT    NOT NULL,
+		action     TEXT    NOT NULL,
+		record_uri TEXT    NOT NULL,
+		dry_run    INTEGER NOT NULL
+	)`,
+	`CREATE INDEX actions_post_uri ON actions (post_uri)`,
+}
+
+// historyDB is the SQLite database recording every action of the runs.
+type historyDB struct {
+	db *sql.DB
+}
+
+// openHistory opens the SQLite database at path, creating it and its tables on first use.
+func openHistory(path string) (*historyDB, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database %s: %w", path, err)
+	}
+	if err := migrateHistory(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate database %s: %w", path, err)
+	}
+	return &historyDB{db: db}, nil
+}
+
+// migrateHistory applies the historyMigrations not yet applied to db, in a single transaction.
+func migrateHistory(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+	if version >= len(historyMigrations) {
+		return nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmt := range historyMigrations[version:] {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	// PRAGMA does not take parameters; the value is an int.
+	if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, len(historyMigrations))); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// record stores one row per like and per repost of actions.
+func (h *historyDB) record(actions []reposter.ActionedPost) error {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to record actions: %w", err)
+	}
+	defer tx.Rollback()
+	insert := `INSERT INTO actions (at, target_did, post_uri, action, record_uri, dry_run) VALUES (?, ?, ?, ?, ?, ?)`
+	for _, a := range actions {
+		rows := []struct{ action, recordURI string }{{"like", a.LikeURI}, {"repost", a.RepostURI}}
+		switch a.Action {
+		case "like":
+			rows = rows[:1]
+		case "repost":
+			rows = rows[1:]
+		}
+		for _, row := range rows {
+			_, err := tx.Exec(insert, reposter.FormatTimestamp(a.At), a.Target, a.URI, row.action, row.recordURI, a.DryRun)
+			if err != nil {
+				return fmt.Errorf("failed to record %s of post URI %s: %w", row.action, a.URI, err)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to record actions: %w", err)
+	}
+	return nil
+}
+
+// print writes the recorded actions to w as a table, oldest first.
+func (h *historyDB) print(w io.Writer) error {
+	rows, err := h.db.Query(`SELECT at, target_did, post_uri, action, record_uri, dry_run FROM actions ORDER BY at, id`)
+	if err != nil {
+		return fmt.Errorf("failed to read history: %w", err)
+	}
+	defer rows.Close()
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "AT\tTARGET\tPOST\tACTION\tRECORD\tDRY RUN")
+	for rows.Next() {
+		var at, target, post, action, record string
+		var dryRun bool
+		if err := rows.Scan(&at, &target, &post, &action, &record, &dryRun); err != nil {
+			return fmt.Errorf("failed to read history: %w", err)
+		}
+		if t, err := reposter.ParseTimestamp(at); err == nil {
+			at = t.Local().Format(time.DateTime)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%t\n", at, target, post, action, record, dryRun)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read history: %w", err)
+	}
+	return tw.Flush()
+}
+
+// Close closes the database.
+func (h *historyDB) Close() error {
+	return h.db.Close()
+}
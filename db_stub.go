@@ -0,0 +1,31 @@
+//go:build !sqlite
+
+package main
+
+import (
+	"errors"
+	"io"
+
+	"github.com/carlo-colombo/bs-reposter-liker/reposter"
+)
+
+// errNoSQLite is returned when the binary was built without the sqlite build tag.
+var errNoSQLite = errors.New("SQLite support not compiled in; rebuild with -tags sqlite")
+
+type historyDB struct{}
+
+func openHistory(path string) (*historyDB, error) {
+	return nil, errNoSQLite
+}
+
+func (h *historyDB) record(actions []reposter.ActionedPost) error {
+	return errNoSQLite
+}
+
+func (h *historyDB) print(w io.Writer) error {
+	return errNoSQLite
+}
+
+func (h *historyDB) Close() error {
+	return nil
+}
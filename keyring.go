@@ -0,0 +1,40 @@
+//go:build keyring
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/zalando/go-keyring"
+	"golang.org/x/term"
+)
+
+// keyringService is the service name under which app passwords are stored, keyed by handle.
+const keyringService = "bs-reposter-liker"
+
+// keyringPassword returns the app password stored in the OS keyring for handle.
+func keyringPassword(handle string) (string, error) {
+	password, err := keyring.Get(keyringService, handle)
+	if err != nil {
+		return "", fmt.Errorf("failed to read password for %s from keyring: %w", handle, err)
+	}
+	return password, nil
+}
+
+// runLogin prompts for the app password of handle and stores it in the OS keyring.
+func runLogin(handle string) error {
+	fmt.Fprintf(os.Stderr, "App password for %s: ", handle)
+	password, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return fmt.Errorf("failed to read password: %w", err)
+	}
+	if len(password) == 0 {
+		return fmt.Errorf("empty password")
+	}
+	if err := keyring.Set(keyringService, handle, string(password)); err != nil {
+		return fmt.Errorf("failed to store password for %s in keyring: %w", handle, err)
+	}
+	return nil
+}
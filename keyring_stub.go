@@ -0,0 +1,16 @@
+//go:build !keyring
+
+package main
+
+import "errors"
+
+// errNoKeyring is returned when the binary was built without the keyring build tag.
+var errNoKeyring = errors.New("keyring support not compiled in; rebuild with -tags keyring")
+
+func keyringPassword(handle string) (string, error) {
+	return "", errNoKeyring
+}
+
+func runLogin(handle string) error {
+	return errNoKeyring
+}
@@ -0,0 +1,10 @@
+package main
+
+import "errors"
+
+// errLockHeld is returned by acquireLock when another run holds the lock.
+var errLockHeld = errors.New("lock is held by another run")
+
+// exitLockHeld is the exit status when --lock-file is held by another run (EX_TEMPFAIL),
+// so cron wrappers can tell an overlapping run from a failed one.
+const exitLockHeld = 75
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+func acquireLock(path string) (release func(), err error) {
+	return nil, errors.New("--lock-file is only supported on Unix systems")
+}
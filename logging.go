@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// newLogger builds the program's logger. format is "text" or "json"; level is a slog level name
+// such as "debug" or "info". File and line information is added when addSource is set or the level is debug.
+func newLogger(w io.Writer, format, level string, addSource bool) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q: %w", level, err)
+	}
+	opts := &slog.HandlerOptions{
+		Level:     lvl,
+		AddSource: addSource || lvl <= slog.LevelDebug,
+	}
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q, expected text or json", format)
+	}
+}
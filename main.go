@@ -1,56 +1,498 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"strings"
+	"sync"
+	"text/template"
 	"time"
 
-	"github.com/bluesky-social/indigo/api/atproto"
 	"github.com/bluesky-social/indigo/api/bsky"
-	"github.com/bluesky-social/indigo/lex/util"
-	"github.com/bluesky-social/indigo/xrpc"
-	"golang.org/x/exp/slices"
-)
-
-const (
-	BlueskyPDS = "https://bsky.social" // The default PDS for Bluesky
+	"github.com/carlo-colombo/bs-reposter-liker/reposter"
 )
 
 func main() {
-	// Initialize slog logger. Using a TextHandler for console readability.
-	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{}))
-	slog.SetDefault(logger)
-
 	// --- Define command-line flags ---
 	dryRun := flag.Bool("dry-run", false, "Enable dry run mode (no actual likes or reposts will be performed)")
+	collectBudget := flag.Duration("collect-budget", 0, "Maximum wall-clock time to spend paginating the target feed (0 means no limit)")
+	maxPages := flag.Int("max-pages", 0, "Maximum number of feed pages to fetch per target (0 means no limit)")
+	pageDelay := flag.Duration("page-delay", time.Second, "Pause between two feed page fetches (negative means none)")
+	authToken := flag.String("auth-token", "", "Email sign-in code for accounts with two-factor authentication (overrides BLUESKY_AUTH_FACTOR_TOKEN)")
+	minLikes := flag.Int64("min-likes", 0, "Only action posts with at least this many likes")
+	minReposts := flag.Int64("min-reposts", 0, "Only action posts with at least this many reposts")
+	mediaOnly := flag.Bool("media-only", false, "Only action posts embedding images or video")
+	minTextLength := flag.Int("min-text-length", 0, "Skip posts whose text is shorter than this many characters, counting an emoji as one, e.g. to pass over \"gm\" (0 disables it)")
+	allowEmptyText := flag.Bool("allow-empty-text", false, "With --min-text-length, still action posts without any text, such as bare images")
+	maxPostAge := flag.Duration("max-post-age", 0, "Never action posts created or indexed longer ago than this, whatever the other options (0 disables it)")
+	order := flag.String("order", reposter.OrderOldest, "Order in which eligible posts are actioned: oldest or newest")
+	count := flag.Int("count", 1, "Maximum number of posts to action in this run")
+	source := flag.String("source", reposter.SourceAuthor, "Feed to amplify: author (posts by the target) or likes (posts liked by the target)")
+	authorFilter := flag.String("author-filter", "", "Author feed filter: posts_with_replies, posts_no_replies, posts_with_media, posts_and_author_threads (original posts and self-threads) or posts_with_video (default: the server's, posts_with_replies)")
+	authAttempts := flag.Int("auth-attempts", 3, "Maximum number of attempts to create a session when authentication fails transiently")
+	retryableErrors := flag.String("retryable-errors", strings.Join(reposter.DefaultRetryableErrors, ","), "Comma-separated XRPC error names that are retried; errors without a name, such as network failures, always are")
+	startupWait := flag.Duration("startup-wait", 0, "Keep retrying authentication with backoff for up to this long while the network is not ready yet (DNS or connection errors), e.g. for cron jobs at boot (0 disables it)")
+	sessionFile := flag.String("session-file", "", "Cache the login session in this file between runs, refreshing it instead of logging in again")
+	sessionKey := flag.String("session-key", "", "Encrypt --session-file with AES-GCM using this key (overrides BLUESKY_SESSION_KEY); use a long random value")
+	refreshMargin := flag.Duration("refresh-margin", 2*time.Minute, "Refresh the session before writing when its access token expires within this margin, saving a failed write (0 disables it)")
+	stateFile := flag.String("state-file", "", "Path of the JSON file used to persist state between runs")
+	startFromLatest := flag.Bool("start-from-latest", false, "On the first run, record the newest post as a boundary without actioning anything; later runs only action newer posts (requires --state-file)")
+	writeInterval := flag.Duration("write-interval", 0, "Minimum delay between two writes (likes, reposts, curation records); also used to estimate the duration of dry runs")
+	parallelActions := flag.Bool("parallel-actions", false, "Like and repost each post concurrently instead of one after the other")
+	sandboxRepo := flag.String("sandbox-repo", "", "Write like and repost records to this repo DID instead of your own account, to exercise the write path")
+	actionRules := flag.String("action-rules", "", "Comma-separated category=actions rules restricting the actions on each post by its category, e.g. original=like+repost,reply=like,quote=like; categories: reply, quote, original (neither reply nor quote) and media (images or video, on top of the other category of the post, both rules applying); actions: like, repost, like+repost or none; posts whose category has no rule are liked and reposted")
+	ackReplies := flag.Bool("ack-replies", false, "Only like, never repost, posts replying to your posts or threads")
+	repostRequiresPriorLike := flag.Bool("repost-requires-prior-like", false, "Only repost posts liked by a previous run; unliked posts are just liked now and reposted by a later run")
+	buildVersion, _, _ := buildInfo()
+	userAgent := flag.String("user-agent", "bs-reposter-liker/"+buildVersion, "User-Agent header sent to the PDS; ${VAR} references to environment variables are expanded")
+	showVersion := flag.Bool("version", false, "Print version and build information, then exit")
+	continueOnActionError := flag.Bool("continue-on-action-error", true, "Keep actioning the remaining posts after a failed like or repost; when false, abort on the first failure")
+	noBoundaryShortcut := flag.Bool("no-boundary-shortcut", false, "Keep paginating the target feed past the first post already liked and reposted, to catch older posts whose like or repost was undone; every run then fetches the whole feed, up to --max-pages and --collect-budget, costing one request per page")
+	stopOnRepeatPage := flag.Bool("stop-on-repeat-page", false, "Stop paginating the target feed at the first page holding only posts already seen, instead of following a drifting cursor deeper")
+	failureThreshold := flag.Int("failure-threshold", 5, "Abort the run after this many consecutive posts with a failed like or repost (0 disables it)")
+	resolvePDS := flag.Bool("resolve-pds", false, "Read the target's feed from the PDS listed in their DID document instead of your own PDS")
+	pick := flag.String("pick", "", "Strategy choosing which eligible posts to action: oldest, newest, most-liked, most-reposted or has-media (defaults to --order)")
+	randomize := flag.Bool("randomize", false, "Action eligible posts in a random order instead of by chronology (cannot be combined with --order or --pick)")
+	seed := flag.Uint64("seed", 0, "Seed for --randomize and --created-at-jitter, to reproduce a previous run (0 picks a random seed, which is logged)")
+	createdAtJitter := flag.Duration("created-at-jitter", 0, "Backdate the createdAt of like and repost records by a random amount up to this, e.g. 5s, so they do not carry perfectly aligned timestamps (at most 1m, never in the future)")
+	resumeScan := flag.Bool("resume-scan", false, "Save the feed cursor in --state-file after every page of a full scan, so an interrupted oldest-first run resumes paging where it stopped")
+	resumeStaleness := flag.Duration("resume-staleness", time.Hour, "Discard a saved --resume-scan cursor older than this, since cursors can expire (0 keeps it indefinitely)")
+	firstRunMarker := flag.Bool("first-run-marker", false, "Cap the first live run against a target, detected by its absence from --state-file, to --first-run-cap posts whatever --count")
+	firstRunCap := flag.Int("first-run-cap", 1, "Maximum number of posts actioned by the first run with --first-run-marker (at least 1; 0 is rejected)")
+	stateRetention := flag.Duration("state-retention", 90*24*time.Hour, "Prune actions older than this from --state-file on every save and with the compact subcommand (0 keeps them all)")
+	dailyCap := flag.Int("daily-cap", 0, "Maximum likes plus reposts in any rolling 24 hours, tracked in --state-file (0 means no cap)")
+	pinActioned := flag.Bool("pin-actioned", false, "After the run, pin the last post it reposted on your profile")
+	postSummary := flag.Bool("post-summary", false, "After a run that liked or reposted something, post a summary of the last 24 hours to your own feed (requires --state-file)")
+	summaryInterval := flag.Duration("summary-interval", 24*time.Hour, "Minimum time between two --post-summary posts")
+	catchupRate := flag.String("catchup-rate", "", "Action at most N posts per period, e.g. 5/1h, spacing them evenly and tracking progress in --state-file, to work through a backlog gradually")
+	activeHours := flag.String("active-hours", "", "Only act during this daily window, e.g. 09:00-22:00 or 22:00-06:00 across midnight; outside it the program exits without acting")
+	timezone := flag.String("timezone", "Local", "IANA time zone of --active-hours, e.g. Europe/Rome")
+	authorCooldown := flag.Duration("author-cooldown", 0, "Repost at most one post per author in any window of this length, tracked in --state-file across runs (0 disables it)")
+	viaFeed := flag.String("via-feed", "", "Credit this feed generator (at://.../app.bsky.feed.generator/...) in the via field of created repost records")
+	curateCollection := flag.String("curate-collection", "", "NSID of a collection in which to also create a record referencing each reposted post (e.g. com.example.curated.item)")
+	interactive := flag.Bool("interactive", false, "Show the posts about to be liked and reposted and ask for confirmation before a live run writes anything (requires a terminal)")
+	diagnose := flag.Bool("diagnose", false, "Print to stdout how many posts were left at each selection stage (collected, passed-author, passed-filters, not-already-actioned, selected) and which filters dropped the others")
+	pretty := flag.Bool("pretty", false, "Print a human-friendly summary line to stdout at the end of the run")
+	includeReposts := flag.Bool("include-reposts", false, "Also amplify posts the target reposted; the original post is liked and reposted")
+	skipOwn := flag.Bool("skip-own", false, "Never action posts authored by your own account")
+	skipPriorEngagement := flag.Bool("skip-prior-engagement", false, "Skip posts you already replied to or quoted, checked against your latest 1000 posts")
+	skipTargetOwn := flag.Bool("skip-target-own", false, "Never action posts authored by the target (with --source=likes)")
+	explain := flag.Bool("explain", false, "Log one line per collected post listing every filter it passed or failed and whether it was chosen")
+	dumpFeed := flag.String("dump-feed", "", "Write the collected feed to this JSON file before selecting posts, for offline analysis")
+	simulateFrom := flag.String("simulate-from-file", "", "Run the filters and selection against a feed written by --dump-feed instead of the network and print the plan; needs no credentials and actions nothing")
+	threadDedup := flag.Bool("thread-dedup", false, "Action at most one eligible post per thread")
+	threadPick := flag.String("thread-pick", reposter.ThreadPickRoot, "Post kept by --thread-dedup: root or most-engaged")
+	sortBy := flag.String("sort-by", reposter.SortIndexedAt, "Timestamp defining post chronology: indexedAt or createdAt")
+	pdsHost := flag.String("pds", reposter.BlueskyPDS, "URL of the PDS to log in to")
+	caFile := flag.String("ca-file", "", "PEM file with extra certificate authorities to trust (e.g. for a self-hosted PDS)")
+	insecureSkipVerify := flag.Bool("insecure-skip-verify", false, "DANGEROUS: disable TLS certificate verification; only for testing against a self-signed PDS")
+	useOAuth := flag.Bool("oauth", false, "Authenticate with pre-provisioned OAuth client credentials (DPoP-bound tokens) instead of an app password")
+	oauthClientID := flag.String("oauth-client-id", "", "OAuth client ID (URL of the client metadata document)")
+	oauthClientKey := flag.String("oauth-client-key", "", "PEM file with the ES256 key authenticating the OAuth client")
+	oauthClientKeyID := flag.String("oauth-client-key-id", "", "Key ID of --oauth-client-key in the client's JWKS")
+	oauthDPoPKey := flag.String("oauth-dpop-key", "", "PEM file with the ES256 key the OAuth tokens are bound to")
+	oauthTokenFile := flag.String("oauth-token-file", "", "JSON file holding the OAuth refresh token ({\"refreshToken\": ...}); rewritten with the rotated token on every run")
+	useKeyring := flag.Bool("keyring", false, "Read the app password from the OS keyring (stored with the login subcommand), falling back to BLUESKY_PASSWORD; requires a build with -tags keyring")
+	timeout := flag.Duration("timeout", 0, "Maximum duration of the whole run (0 means no limit)")
+	connectTimeout := flag.Duration("connect-timeout", 0, "Maximum time to establish a connection to the PDS and for each authentication attempt (0 means no limit)")
+	readTimeout := flag.Duration("read-timeout", 0, "Maximum time for each feed page fetch, retries included (0 keeps the default of 30s per request)")
+	writeTimeout := flag.Duration("write-timeout", 0, "Maximum time for each like, repost or curation record write, retries included (0 keeps the default of 30s per request)")
+	proxy := flag.String("proxy", "", "Proxy URL for all PDS requests, e.g. http://proxy.example:3128 (default: HTTP_PROXY, HTTPS_PROXY and NO_PROXY)")
+	logLevel := flag.String("log-level", "info", "Minimum log level: debug, info, warn or error (debug also adds source locations)")
+	logFormat := flag.String("log-format", "text", "Log output format: text or json")
+	logSource := flag.Bool("log-source", false, "Add the source file and line to every log line")
+	search := flag.String("search", "", "Amplify the latest posts matching this search query, by anyone, e.g. '#buildinpublic', instead of TARGET_USER_DID")
+	searchLimit := flag.Int("search-limit", reposter.DefaultSearchLimit, "Maximum number of search results collected per run with --search; pages are spaced by --page-delay to respect rate limits")
+	starterPack := flag.String("starter-pack", "", "Amplify every member of this starter pack (at://...) instead of TARGET_USER_DID")
+	configFile := flag.String("config", "", "JSON file of default flag values, keyed by flag name (e.g. {\"count\": 3}); command-line flags take precedence and unknown keys are errors")
+	actionTemplate := flag.String("action-template", "", "Go text/template printed to stdout for every liked or reposted post, e.g. '{{.Action}} {{.URI}} by {{.AuthorHandle}}'; fields: Action, URI, CID, AuthorDID, AuthorHandle, Text, DryRun, At, Target, LikeURI, RepostURI")
+	events := flag.Bool("events", false, "Write one NDJSON event per run step to stdout and logs to stderr; events: auth_ok, page_fetched, post_selected, action_performed, action_failed, run_complete, each with type, ts and event-specific fields")
+	lockFile := flag.String("lock-file", "", "Hold an exclusive lock on this file for the whole run; if another run holds it, exit immediately with status 75")
+	minFollowers := flag.Int64("min-followers", 0, "Skip targets with fewer followers than this, e.g. to avoid spam accounts recently added to a starter pack")
+	minAccountAge := flag.Duration("min-account-age", 0, "Skip targets whose account was created more recently than this (0 disables it)")
+	allowSelf := flag.Bool("allow-self", false, "Allow TARGET_USER_DID to be your own account; without it such a run exits with an error")
+	diff := flag.Bool("diff", false, "With --dry-run, only report eligible posts that are new since the previous --diff run and not yet actioned (requires --state-file)")
+	failOnEmptyPlan := flag.Bool("fail-on-empty-plan", false, "With --dry-run, exit non-zero when no post would be liked or reposted, e.g. to gate a config change in CI")
+	labeler := flag.String("labeler", "", "DID of a labeler queried before actioning each post; posts it labeled, directly or through their author, with one of --block-labels are skipped")
+	blockLabels := flag.String("block-labels", "", "Comma-separated label values of --labeler that make a post be skipped, e.g. spam,misleading")
+	dbPath := flag.String("db", "", "Record every like and repost, dry runs included, to this SQLite database, created on first use, and read it with the history subcommand; requires a build with -tags sqlite")
+	pprofAddr := flag.String("pprof-addr", "", "Serve the net/http/pprof profiling endpoints on this address during the run, e.g. localhost:6060; profiles expose memory contents, never make it publicly reachable")
+	otelEndpoint := flag.String("otel-endpoint", "", "Export OpenTelemetry traces of the run (auth, feed pages, actions) over OTLP/HTTP to this URL, e.g. http://localhost:4318")
+	var postURIs stringList
+	var onlyURIs stringList
+	var muteWords stringList
+	var targetFlags stringList
+	var selfLabels stringList
+	flag.Var(&targetFlags, "target", "Target account as did:... or did:...=weight, instead of TARGET_USER_DID; repeatable, --count is shared by weight across runs (requires --state-file). In --config files an entry can be an object with its own policy: {\"did\": ..., \"weight\": 2, \"actions\": [\"like\", \"repost\" or \"quote\"], \"count\": 1, \"filter\": \"posts_no_replies\", \"quote-text\": ...}")
+	quoteText := flag.String("quote-text", "", "Quote each post with this text instead of reposting it; targets of --config with their own actions keep them; ${VAR} references to environment variables are expanded")
+	quoteFrom := flag.String("quote-from", "", "Take the text of each quote post from the quoted post, falling back to the quote text: external-title uses the title of its link card")
+	flag.Var(&selfLabels, "self-label", "Attach this self-label to the quote posts, one of !no-unauthenticated, porn, sexual, nudity or graphic-media; repeatable")
+	flag.Var(&muteWords, "mute-word", "Skip posts whose text contains this word or phrase, ignoring case (repeatable)")
+	muteFile := flag.String("mute-file", "", "File of words or phrases to mute, one per line; blank lines and lines starting with # are ignored")
+	muteWholeWord := flag.Bool("mute-whole-word", false, "Only match muted words when not part of a longer word")
+	flag.Var(&postURIs, "post-uri", "Like and repost this post (at://...) instead of scanning the target feed; repeatable")
+	flag.Var(&onlyURIs, "only-uri", "Only action this post (at://...), if found in the scanned feed and eligible; repeatable, allowlisted posts not found are logged")
+	onlyFile := flag.String("only-file", "", "File of post URIs to add to the --only-uri allowlist, one per line; blank lines and lines starting with # are ignored")
 	flag.Parse() // Parse the command-line flags
+	if *configFile != "" {
+		if err := loadConfigFile(*configFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	expandEnvFlags()
+
+	// Initialize slog logger. The text handler is the default for console readability.
+	logOut := os.Stdout
+	if *events {
+		// Keep stdout for the event stream.
+		logOut = os.Stderr
+	}
+	logger, err := newLogger(logOut, *logFormat, *logLevel, *logSource)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	slog.SetDefault(logger)
+	defer recoverPanic(*stateFile)
+
+	if *showVersion || flag.Arg(0) == "version" {
+		printVersion()
+		return
+	}
+	if flag.Arg(0) == "login" {
+		handle := os.Getenv("BLUESKY_HANDLE")
+		if handle == "" {
+			slog.Error("BLUESKY_HANDLE environment variable not set. Exiting.", "error", "missing_env_var")
+			os.Exit(1)
+		}
+		if err := runLogin(handle); err != nil {
+			slog.Error("Login failed", "error", err)
+			os.Exit(1)
+		}
+		slog.Info("App password stored in the OS keyring", "handle", handle)
+		return
+	}
+	if flag.Arg(0) == "history" {
+		if *dbPath == "" {
+			slog.Error("The history subcommand requires --db. Exiting.")
+			os.Exit(1)
+		}
+		history, err := openHistory(*dbPath)
+		if err != nil {
+			slog.Error("Failed to open --db", "error", err)
+			os.Exit(1)
+		}
+		defer history.Close()
+		if err := history.print(os.Stdout); err != nil {
+			slog.Error("Failed to print history", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if flag.Arg(0) == "compact" {
+		if *stateFile == "" || *stateRetention <= 0 {
+			slog.Error("The compact subcommand requires --state-file and a positive --state-retention. Exiting.")
+			os.Exit(1)
+		}
+		pruned, err := reposter.CompactState(*stateFile, *stateRetention)
+		if err != nil {
+			slog.Error("Compacting state file failed", "error", err)
+			os.Exit(1)
+		}
+		slog.Info("State file compacted", "stateFile", *stateFile, "pruned", pruned, "retention", *stateRetention)
+		return
+	}
 
 	// --- Configuration: Read from Environment Variables ---
 	yourHandle := os.Getenv("BLUESKY_HANDLE")
 	yourPassword := os.Getenv("BLUESKY_PASSWORD")
 	targetUserDID := os.Getenv("TARGET_USER_DID")
+	targetUserHandle := os.Getenv("TARGET_USER_HANDLE") // Optional, only used to detect handle changes
+	authFactorToken := os.Getenv("BLUESKY_AUTH_FACTOR_TOKEN")
+	if *authToken != "" {
+		authFactorToken = *authToken
+	}
+	sessionKeyValue := os.Getenv("BLUESKY_SESSION_KEY")
+	if *sessionKey != "" {
+		sessionKeyValue = *sessionKey
+	}
+	if *useKeyring && yourHandle != "" {
+		if password, err := keyringPassword(yourHandle); err != nil {
+			slog.Warn("Keyring unavailable, falling back to BLUESKY_PASSWORD", "error", err)
+		} else {
+			yourPassword = password
+		}
+	}
 
 	// Validate environment variables
-	if yourHandle == "" {
+	if yourHandle == "" && !*useOAuth && *simulateFrom == "" {
 		slog.Error("BLUESKY_HANDLE environment variable not set. Exiting.", "error", "missing_env_var")
 		os.Exit(1)
 	}
-	if yourPassword == "" {
+	if yourPassword == "" && !*useOAuth && *simulateFrom == "" {
 		slog.Error("BLUESKY_PASSWORD environment variable not set. Please use an app password. Exiting.", "error", "missing_env_var")
 		os.Exit(1)
 	}
-	if targetUserDID == "" {
+	var targets []reposter.WeightedTarget
+	for _, raw := range targetFlags {
+		t, err := reposter.ParseWeightedTarget(raw)
+		if err != nil {
+			slog.Error("Invalid --target. Exiting.", "error", err)
+			os.Exit(1)
+		}
+		targets = append(targets, t)
+	}
+	if targetUserDID == "" && len(postURIs) == 0 && *starterPack == "" && len(targets) == 0 && *search == "" && *simulateFrom == "" {
 		slog.Error("TARGET_USER_DID environment variable not set. Exiting.", "error", "missing_env_var")
 		os.Exit(1)
 	}
 
-	slog.Info("Starting Bluesky Auto Reposter and Liker - Stateless Mode",
+	var rate reposter.Rate
+	if *catchupRate != "" {
+		var err error
+		if rate, err = reposter.ParseRate(*catchupRate); err != nil {
+			slog.Error("Invalid --catchup-rate. Exiting.", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	var hours *reposter.ActiveHours
+	if *activeHours != "" {
+		loc, err := time.LoadLocation(*timezone)
+		if err != nil {
+			slog.Error("Invalid --timezone. Exiting.", "error", err)
+			os.Exit(1)
+		}
+		if hours, err = reposter.ParseActiveHours(*activeHours, loc); err != nil {
+			slog.Error("Invalid --active-hours. Exiting.", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	var rules reposter.ActionRules
+	if *actionRules != "" {
+		var err error
+		if rules, err = reposter.ParseActionRules(*actionRules); err != nil {
+			slog.Error("Invalid --action-rules. Exiting.", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	var actionTmpl *template.Template
+	if *actionTemplate != "" {
+		var err error
+		if actionTmpl, err = parseActionTemplate(*actionTemplate); err != nil {
+			slog.Error("Invalid --action-template. Exiting.", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	if *events && (*pretty || *diagnose || actionTmpl != nil) {
+		slog.Error("--events cannot be combined with --pretty, --diagnose or --action-template, which also write to stdout. Exiting.")
+		os.Exit(1)
+	}
+
+	if *failOnEmptyPlan && !*dryRun {
+		slog.Error("--fail-on-empty-plan requires --dry-run. Exiting.")
+		os.Exit(1)
+	}
+
+	if *muteFile != "" {
+		words, err := reposter.ReadWordList(*muteFile)
+		if err != nil {
+			slog.Error("Failed to read --mute-file. Exiting.", "error", err)
+			os.Exit(1)
+		}
+		muteWords = append(muteWords, words...)
+	}
+	if *onlyFile != "" {
+		uris, err := reposter.ReadWordList(*onlyFile)
+		if err != nil {
+			slog.Error("Failed to read --only-file. Exiting.", "error", err)
+			os.Exit(1)
+		}
+		onlyURIs = append(onlyURIs, uris...)
+	}
+
+	if *randomize {
+		// --order has a non-empty default: only an explicit value conflicts with --randomize.
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "order" || f.Name == "pick" {
+				slog.Error("--randomize cannot be combined with --order or --pick. Exiting.")
+				os.Exit(1)
+			}
+		})
+		*order = ""
+	}
+
+	filters := reposter.Filters{
+		MinLikes:      *minLikes,
+		MinReposts:    *minReposts,
+		MaxPostAge:    *maxPostAge,
+		MediaOnly:     *mediaOnly,
+		MuteWords:     muteWords,
+		MuteWholeWord: *muteWholeWord,
+
+		MinTextLength:  *minTextLength,
+		AllowEmptyText: *allowEmptyText,
+	}
+	cfg := reposter.Config{
+		Handle:             yourHandle,
+		Password:           yourPassword,
+		AuthFactorToken:    authFactorToken,
+		AuthAttempts:       *authAttempts,
+		RetryableErrors:    splitList(*retryableErrors),
+		StartupWait:        *startupWait,
+		SessionFile:        *sessionFile,
+		SessionKey:         sessionKeyValue,
+		RefreshMargin:      *refreshMargin,
+		UserAgent:          *userAgent,
+		PDSHost:            *pdsHost,
+		CAFile:             *caFile,
+		InsecureSkipVerify: *insecureSkipVerify,
+		Proxy:              *proxy,
+		ConnectTimeout:     *connectTimeout,
+		ReadTimeout:        *readTimeout,
+		WriteTimeout:       *writeTimeout,
+		TargetDID:          targetUserDID,
+		TargetHandle:       targetUserHandle,
+		AllowSelf:          *allowSelf,
+		MinFollowers:       *minFollowers,
+		MinAccountAge:      *minAccountAge,
+		PostURIs:           postURIs,
+		OnlyURIs:           onlyURIs,
+		StarterPack:        *starterPack,
+		Search:             *search,
+		SearchLimit:        *searchLimit,
+		Targets:            targets,
+		SkipOwn:            *skipOwn,
+		SkipEngaged:        *skipPriorEngagement,
+		SkipTargetOwn:      *skipTargetOwn,
+		Explain:            *explain,
+		DumpFeed:           *dumpFeed,
+		ThreadDedup:        *threadDedup,
+		ThreadPick:         *threadPick,
+		Source:             *source,
+		AuthorFilter:       *authorFilter,
+		Order:              *order,
+		Pick:               *pick,
+		Randomize:          *randomize,
+		Seed:               *seed,
+		SortBy:             *sortBy,
+		Count:              *count,
+		CollectBudget:      *collectBudget,
+		MaxPages:           *maxPages,
+		PageDelay:          *pageDelay,
+		ResolvePDS:         *resolvePDS,
+		StopOnRepeat:       *stopOnRepeatPage,
+		NoBoundaryStop:     *noBoundaryShortcut,
+		IncludeReposts:     *includeReposts,
+		Filters:            filters,
+		DryRun:             *dryRun,
+		Diff:               *diff,
+		ParallelActions:    *parallelActions,
+		WriteInterval:      *writeInterval,
+		StopOnActionError:  !*continueOnActionError,
+		FailureThreshold:   *failureThreshold,
+		SandboxRepo:        *sandboxRepo,
+		CurateCollection:   *curateCollection,
+		ViaFeed:            *viaFeed,
+		CreatedAtJitter:    *createdAtJitter,
+		Labeler:            *labeler,
+		BlockLabels:        splitList(*blockLabels),
+		SelfLabels:         selfLabels,
+		QuoteText:          *quoteText,
+		QuoteFrom:          *quoteFrom,
+		StateFile:          *stateFile,
+		ResumeScan:         *resumeScan,
+		ResumeStaleness:    *resumeStaleness,
+		StartFromLatest:    *startFromLatest,
+		DailyCap:           *dailyCap,
+		StateRetention:     *stateRetention,
+		AuthorCooldown:     *authorCooldown,
+		CatchupRate:        rate,
+		ActiveHours:        hours,
+		PinActioned:        *pinActioned,
+		PostSummary:        *postSummary,
+		SummaryInterval:    *summaryInterval,
+
+		RepostRequiresPriorLike: *repostRequiresPriorLike,
+		AckReplies:              *ackReplies,
+		ActionRules:             rules,
+
+		FirstRunMarker: *firstRunMarker,
+		FirstRunCap:    *firstRunCap,
+	}
+	if *useOAuth {
+		cfg.OAuth = &reposter.OAuthConfig{
+			ClientID:      *oauthClientID,
+			ClientKeyFile: *oauthClientKey,
+			ClientKeyID:   *oauthClientKeyID,
+			DPoPKeyFile:   *oauthDPoPKey,
+			TokenFile:     *oauthTokenFile,
+		}
+	}
+	if *events {
+		cfg.OnEvent = writeEvent(json.NewEncoder(os.Stdout))
+	}
+	if *interactive {
+		if !isTerminal(os.Stdin) {
+			slog.Error("--interactive requires stdin to be a terminal. Exiting.")
+			os.Exit(1)
+		}
+		cfg.Confirm = confirmPlan
+	}
+	if *simulateFrom != "" {
+		plan, result, err := reposter.Simulate(cfg, *simulateFrom)
+		if err != nil {
+			slog.Error("Simulation failed", "error", err)
+			os.Exit(1)
+		}
+		printPlan(plan)
+		if *diagnose {
+			fmt.Print(result.Funnel)
+		}
+		slog.Info("Simulation finished.", "posts", len(plan), "likes", result.Liked, "reposts", result.Reposted, "skipped", result.Skipped)
+		return
+	}
+	if err := cfg.Validate(); err != nil {
+		slog.Error("Invalid configuration. Exiting.", "error", err)
+		os.Exit(1)
+	}
+
+	if flag.Arg(0) == "list" {
+		records, err := reposter.ListActioned(context.Background(), cfg)
+		if err != nil {
+			slog.Error("Listing actioned posts failed", reposter.ErrorAttrs(err)...)
+			os.Exit(1)
+		}
+		for _, r := range records {
+			fmt.Printf("%s\t%s\t%s\n", r.CreatedAt, r.Type, r.SubjectURI)
+		}
+		return
+	}
+
+	slog.Info("Starting Bluesky Auto Reposter and Liker",
 		"yourHandle", yourHandle,
 		"targetUserDID", targetUserDID,
 		"dryRun", *dryRun, // Use the value from the flag
+		"collectBudget", *collectBudget,
+		"minLikes", *minLikes,
+		"minReposts", *minReposts,
+		"order", *order,
+		"pick", *pick,
+		"count", *count,
+		"source", *source,
+		"stateFile", *stateFile,
+		"startFromLatest", *startFromLatest,
 	)
 
 	if *dryRun {
@@ -59,205 +501,210 @@ func main() {
 		slog.Info("LIVE RUN MODE IS ACTIVE. Likes and reposts will be performed.")
 	}
 
-	// Create a new XRPC client
-	ctx := context.Background()
+	if *lockFile != "" {
+		release, err := acquireLock(*lockFile)
+		if errors.Is(err, errLockHeld) {
+			slog.Error("Another run holds the lock file, exiting without doing anything.", "lockFile", *lockFile)
+			os.Exit(exitLockHeld)
+		}
+		if err != nil {
+			slog.Error("Failed to acquire lock file. Exiting.", "error", err)
+			os.Exit(1)
+		}
+		defer release()
+	}
 
-	xrpcc, session, err := AuthenticateAndInit(ctx, yourHandle, yourPassword)
-	if err != nil {
-		slog.Error("Authentication failed", "error", err)
+	ctx := context.Background()
+	if *timeout < 0 {
+		slog.Error("Invalid --timeout, must not be negative. Exiting.", "timeout", *timeout)
 		os.Exit(1)
 	}
-	slog.Info("Successfully authenticated",
-		"handle", session.Handle,
-		"did", session.Did,
-	)
-
-	slog.Info("Fetching all posts from target user to find the oldest eligible post...")
-	allTargetUserPosts := CollectAllTargetUserPosts(ctx, xrpcc, targetUserDID)
-	slog.Info("Finished collecting target user's posts", "totalPostsCollected", len(allTargetUserPosts))
-
-	slices.Reverse(allTargetUserPosts)
-	slog.Info("Posts reordered from oldest to newest.")
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
 
-	post := FindOldestEligiblePost(allTargetUserPosts)
-	actionPerformed := false
-	if post != nil {
-		actionPerformed = ProcessPostActions(ctx, xrpcc, post, *dryRun)
+	if *pprofAddr != "" {
+		if err := startPprof(*pprofAddr); err != nil {
+			slog.Error("Failed to start pprof. Exiting.", "error", err)
+			os.Exit(1)
+		}
 	}
 
-	if !actionPerformed {
-		slog.Info("No un-actioned posts found from the target user's collected feed.")
+	var history *historyDB
+	if *dbPath != "" {
+		// Opened before the run, so a missing build tag or a bad path fails before acting.
+		if history, err = openHistory(*dbPath); err != nil {
+			slog.Error("Failed to open --db. Exiting.", "error", err)
+			os.Exit(1)
+		}
+		defer history.Close()
 	}
 
-	slog.Info("Program finished.")
-}
+	shutdownTracing := func(context.Context) error { return nil }
+	if *otelEndpoint != "" {
+		shutdownTracing, err = setupTracing(ctx, *otelEndpoint, buildVersion)
+		if err != nil {
+			slog.Error("Failed to set up tracing. Exiting.", "error", err)
+			os.Exit(1)
+		}
+	}
 
-// AuthenticateAndInit authenticates with Bluesky and returns an authenticated xrpc.Client and session info.
-func AuthenticateAndInit(ctx context.Context, handle, password string) (*xrpc.Client, *atproto.ServerCreateSession_Output, error) {
-	xrpcc := &xrpc.Client{Host: BlueskyPDS}
-	session, err := atproto.ServerCreateSession(ctx, xrpcc, &atproto.ServerCreateSession_Input{
-		Identifier: handle,
-		Password:   password,
-	})
-	if err != nil {
-		return nil, nil, err
+	result, err := reposter.Run(ctx, cfg)
+	// Flush the spans now, even past --timeout: the exits below skip deferred calls.
+	if err := shutdownTracing(context.Background()); err != nil {
+		slog.Warn("Failed to export traces", "otelEndpoint", *otelEndpoint, "error", err)
 	}
-	xrpcc.Auth = &xrpc.AuthInfo{
-		AccessJwt:  session.AccessJwt,
-		RefreshJwt: session.RefreshJwt,
-		Did:        session.Did,
-		Handle:     session.Handle,
+	// Recorded before checking err: a failed run may still have acted on some posts.
+	if history != nil && len(result.Actions) > 0 {
+		if err := history.record(result.Actions); err != nil {
+			slog.Error("Failed to record actions to --db", "db", *dbPath, "error", err)
+		}
 	}
-	return xrpcc, session, nil
-}
-
-// CollectAllTargetUserPosts fetches all posts from the target user, stopping at the first fully actioned post.
-func CollectAllTargetUserPosts(ctx context.Context, xrpcc *xrpc.Client, targetUserDID string) []*bsky.FeedDefs_PostView {
-	var allTargetUserPosts []*bsky.FeedDefs_PostView
-	cursor := ""
-
-feedCollect:
-	for {
-		slog.Info("Fetching author feed for target user", "targetUserDID", targetUserDID, "cursor", cursor)
-		feed, err := bsky.FeedGetAuthorFeed(ctx, xrpcc, targetUserDID, cursor, "", false, 10)
-		if err != nil {
-			slog.Error("Failed to get author feed while collecting all posts",
-				"targetUserDID", targetUserDID,
-				"error", err,
-			)
-			break
-		}
-		if len(feed.Feed) == 0 {
-			slog.Info("No more posts to fetch from target user.")
-			break
-		}
-		for _, item := range feed.Feed {
-			slog.Info("Processing feed item", "postUri", item.Post.Uri, "t", item.Post.IndexedAt)
-			post := item.Post
-			if post.Author.Did == targetUserDID {
-				alreadyLiked := post.Viewer != nil && post.Viewer.Like != nil
-				alreadyReposted := post.Viewer != nil && post.Viewer.Repost != nil
-				if alreadyLiked && alreadyReposted {
-					break feedCollect
-				}
-				allTargetUserPosts = append(allTargetUserPosts, post)
+	if err != nil {
+		slog.Error("Run failed", reposter.ErrorAttrs(err)...)
+		if reposter.XRPCErrorName(err) == "AuthFactorTokenRequired" {
+			if authFactorToken == "" {
+				slog.Error("This account has email two-factor authentication enabled. A sign-in code (email OTP) was sent to the account's email address; re-run with --auth-token <code> or set BLUESKY_AUTH_FACTOR_TOKEN.")
 			} else {
-				slog.Debug("Skipping feed item, not directly authored by target user",
-					"postUri", post.Uri,
-					"authorDid", post.Author.Did,
-					"targetUserDID", targetUserDID,
-				)
+				slog.Error("The provided two-factor sign-in code was rejected. Codes expire quickly; request a new one by re-running without --auth-token and check your email.")
 			}
 		}
-		slog.Info("Cursor for next page", "cursor", *feed.Cursor)
-		if feed.Cursor != nil && *feed.Cursor != "" {
-			cursor = *feed.Cursor
-			time.Sleep(1 * time.Second)
-		} else {
-			break
-		}
+		os.Exit(1)
 	}
-	return allTargetUserPosts
-}
 
-// LikePost performs the like action for a given post.
-// It takes an additional isDryRun boolean to determine if the action should be skipped.
-func LikePost(ctx context.Context, xrpcc *xrpc.Client, uri, cid string, isDryRun bool) error {
-	if isDryRun {
-		slog.Info("DRY RUN: Would have liked post", "postUri", uri)
-		return nil
+	if actionTmpl != nil {
+		for _, action := range result.Actions {
+			if err := actionTmpl.Execute(os.Stdout, action); err != nil {
+				slog.Error("Failed to render --action-template", "postUri", action.URI, "error", err)
+			}
+		}
+	}
+	if *pretty {
+		fmt.Println(result.Pretty(*dryRun))
+	}
+	if *diagnose {
+		fmt.Print(result.Funnel)
 	}
 
-	record := &bsky.FeedLike{
-		Subject: &atproto.RepoStrongRef{
-			Cid: cid,
-			Uri: uri,
-		},
-		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	if len(result.FailedURIs) > 0 {
+		slog.Error("Program finished with failed actions.", "failedUris", result.FailedURIs)
+		os.Exit(1)
 	}
+	if result.ScanErr != nil {
+		slog.Error("Program finished with an incomplete feed scan.", "pagesCollected", result.PagesFetched)
+		os.Exit(1)
+	}
+	if *failOnEmptyPlan && result.Liked+result.Reposted == 0 {
+		slog.Error("Program finished with an empty plan: no post would be liked or reposted.", "skipped", result.Skipped)
+		os.Exit(1)
+	}
+	slog.Info("Program finished.")
+}
 
-	_, err := atproto.RepoCreateRecord(ctx, xrpcc, &atproto.RepoCreateRecord_Input{
-		Repo:       xrpcc.Auth.Did,
-		Collection: "app.bsky.feed.like",
-		Record:     &util.LexiconTypeDecoder{Val: record},
-	})
-	if err != nil {
-		return fmt.Errorf("failed to like post URI %s: %w", uri, err)
+// writeEvent returns a Config.OnEvent callback encoding each event as one line of JSON with enc.
+func writeEvent(enc *json.Encoder) func(reposter.Event) {
+	var mu sync.Mutex
+	return func(e reposter.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err := enc.Encode(e); err != nil {
+			slog.Error("Failed to write event", "type", e.Type, "error", err)
+		}
 	}
-	slog.Info("Successfully liked post", "postUri", uri)
-	return nil
 }
 
-// RepostPost performs the repost action for a given post.
-// It takes an additional isDryRun boolean to determine if the action should be skipped.
-func RepostPost(ctx context.Context, xrpcc *xrpc.Client, uri, cid string, isDryRun bool) error {
-	if isDryRun {
-		slog.Info("DRY RUN: Would have reposted post", "postUri", uri)
-		return nil
+// parseActionTemplate parses an --action-template, appending a newline if it lacks one, and
+// renders it once against an empty action so references to unknown fields fail at startup.
+func parseActionTemplate(text string) (*template.Template, error) {
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
 	}
+	tmpl, err := template.New("action").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if err := tmpl.Execute(io.Discard, reposter.ActionedPost{}); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
 
-	record := &bsky.FeedRepost{
-		Subject: &atproto.RepoStrongRef{
-			Cid: cid,
-			Uri: uri,
-		},
-		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+// confirmPlan prints the posts about to be actioned and asks for confirmation on stdin.
+func confirmPlan(plan []*bsky.FeedDefs_PostView) bool {
+	fmt.Fprintf(os.Stderr, "About to like and repost %d post(s):\n", len(plan))
+	for _, post := range plan {
+		var text []rune
+		if post.Record != nil {
+			if record, ok := post.Record.Val.(*bsky.FeedPost); ok {
+				text = []rune(record.Text)
+			}
+		}
+		if len(text) > 80 {
+			text = append(text[:77], []rune("...")...)
+		}
+		fmt.Fprintf(os.Stderr, "  %s  @%s  %q\n", post.Uri, post.Author.Handle, string(text))
 	}
+	fmt.Fprint(os.Stderr, "Proceed? [y/N] ")
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
 
-	_, err := atproto.RepoCreateRecord(ctx, xrpcc, &atproto.RepoCreateRecord_Input{
-		Repo:       xrpcc.Auth.Did,
-		Collection: "app.bsky.feed.repost",
-		Record:     &util.LexiconTypeDecoder{Val: record},
-	})
-	if err != nil {
-		return fmt.Errorf("failed to repost post URI %s: %w", uri, err)
+// printPlan prints the writes each post of plan needs, one post per line.
+func printPlan(plan []reposter.PostAction) {
+	for _, p := range plan {
+		var actions []string
+		if p.Like {
+			actions = append(actions, "like")
+		}
+		if p.Repost {
+			actions = append(actions, "repost")
+		}
+		fmt.Printf("%s\t%s\t@%s\n", strings.Join(actions, "+"), p.Post.Uri, p.Post.Author.Handle)
 	}
-	slog.Info("Successfully reposted post", "postUri", uri)
-	return nil
 }
 
-// ProcessPostActions likes and/or reposts the given post if needed.
-func ProcessPostActions(ctx context.Context, xrpcc *xrpc.Client, post *bsky.FeedDefs_PostView, dryRun bool) bool {
-	alreadyLiked := post.Viewer != nil && post.Viewer.Like != nil
-	alreadyReposted := post.Viewer != nil && post.Viewer.Repost != nil
+// isTerminal reports whether f is a character device, such as an interactive terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
 
-	slog.Info("Found oldest eligible post to action",
-		"postUri", post.Uri,
-		"authorDisplayName", post.Author.DisplayName,
-		"alreadyLiked", alreadyLiked,
-		"alreadyReposted", alreadyReposted,
-	)
+// expandedFlags lists the textual flags whose values get environment variables expanded.
+// Paths and other flags are deliberately left alone to avoid surprises.
+var expandedFlags = []string{"user-agent", "quote-text"}
 
-	if !alreadyLiked {
-		err := LikePost(ctx, xrpcc, post.Uri, post.Cid, dryRun)
-		if err != nil {
-			slog.Error("Error liking post", "postUri", post.Uri, "error", err)
+// expandEnvFlags replaces ${VAR} and $VAR references in the values of expandedFlags.
+func expandEnvFlags() {
+	for _, name := range expandedFlags {
+		if f := flag.Lookup(name); f != nil {
+			_ = f.Value.Set(os.ExpandEnv(f.Value.String()))
 		}
-	} else {
-		slog.Debug("Post already liked, skipping like action", "postUri", post.Uri)
 	}
+}
 
-	if !alreadyReposted {
-		err := RepostPost(ctx, xrpcc, post.Uri, post.Cid, dryRun)
-		if err != nil {
-			slog.Error("Error reposting post", "postUri", post.Uri, "error", err)
+// splitList splits a comma-separated flag value, trimming spaces and dropping empty items.
+// The result is never nil, so an empty value means an empty list rather than a default.
+func splitList(s string) []string {
+	items := []string{}
+	for item := range strings.SplitSeq(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
 		}
-	} else {
-		slog.Debug("Post already reposted, skipping repost action", "postUri", post.Uri)
 	}
+	return items
+}
+
+// stringList is a flag.Value collecting every occurrence of a repeatable string flag.
+type stringList []string
 
-	slog.Info("Actioned one oldest eligible post. Exiting program.", "postUri", post.Uri)
-	return true
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
 }
 
-// FindOldestEligiblePost returns the first eligible post from the list, or nil if none.
-func FindOldestEligiblePost(posts []*bsky.FeedDefs_PostView) *bsky.FeedDefs_PostView {
-	for _, post := range posts {
-		alreadyLiked := post.Viewer != nil && post.Viewer.Like != nil
-		alreadyReposted := post.Viewer != nil && post.Viewer.Repost != nil
-		if !alreadyLiked || !alreadyReposted {
-			return post
-		}
-	}
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
 	return nil
 }
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"flag"
+	"testing"
+)
+
+func TestExpandEnvFlags(t *testing.T) {
+	defer func(fs *flag.FlagSet) { flag.CommandLine = fs }(flag.CommandLine)
+	flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+	quoteText := flag.String("quote-text", "", "")
+	userAgent := flag.String("user-agent", "", "")
+	stateFile := flag.String("state-file", "", "")
+	t.Setenv("TARGET", "alice.test")
+	t.Setenv("VERSION", "1.2")
+	args := []string{"--quote-text", "New post from ${TARGET}", "--user-agent", "bot/$VERSION", "--state-file", "/tmp/${TARGET}.json"}
+	if err := flag.CommandLine.Parse(args); err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+
+	expandEnvFlags()
+	if want := "New post from alice.test"; *quoteText != want {
+		t.Errorf("--quote-text = %q, want %q", *quoteText, want)
+	}
+	if want := "bot/1.2"; *userAgent != want {
+		t.Errorf("--user-agent = %q, want %q", *userAgent, want)
+	}
+	// Paths are not expanded.
+	if want := "/tmp/${TARGET}.json"; *stateFile != want {
+		t.Errorf("--state-file = %q, want %q", *stateFile, want)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+)
+
+// startPprof serves the net/http/pprof endpoints under /debug/pprof/ on addr, e.g. localhost:6060,
+// in the background for the rest of the process.
+// The profiles expose memory contents and command lines, so addr should stay on the loopback
+// interface: an address without a host listens on every interface and is warned about.
+func startPprof(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for pprof on %s: %w", addr, err)
+	}
+	if host, _, _ := net.SplitHostPort(addr); !isLoopback(host) {
+		slog.Warn("PPROF IS EXPOSED BEYOND LOCALHOST. Profiles reveal memory contents, including credentials; bind --pprof-addr to localhost or firewall it.",
+			"pprofAddr", ln.Addr().String(),
+		)
+	}
+
+	// A dedicated mux, so nothing else registered on http.DefaultServeMux is exposed.
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	go func() {
+		if err := http.Serve(ln, mux); err != nil && !errors.Is(err, net.ErrClosed) {
+			slog.Error("pprof server stopped", "error", err)
+		}
+	}()
+	slog.Info("Serving pprof endpoints", "url", "http://"+ln.Addr().String()+"/debug/pprof/")
+	return nil
+}
+
+// isLoopback reports whether host, from a listen address, only accepts local connections.
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,491 @@
+package reposter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/lex/util"
+	"github.com/bluesky-social/indigo/xrpc"
+	"github.com/rivo/uniseg"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/sync/errgroup"
+)
+
+// ActionOptions controls how ProcessPostActions writes records.
+type ActionOptions struct {
+	DryRun   bool   // Log the actions instead of performing them
+	Parallel bool   // Issue the like and repost concurrently
+	Repo     string // Repository the records are written to; empty means the authenticated account
+
+	Limiter *WriteLimiter // Spaces out writes in live runs; nil means no spacing
+
+	// CurateCollection, when set, is the NSID of a collection in which a record referencing
+	// each reposted post is also created, for consumption by a feed generator.
+	CurateCollection string
+
+	// RepostRequiresPriorLike only reposts posts that were already liked before this run;
+	// posts that are not liked yet are only liked, and get reposted by a later run.
+	RepostRequiresPriorLike bool
+
+	// LikeOnly only likes the post, never reposting it.
+	LikeOnly bool
+
+	// Via, when set, is the feed generator credited in the via field of repost records.
+	Via *atproto.RepoStrongRef
+
+	WriteTimeout time.Duration // When positive, bounds each record write, retries included
+
+	Jitter *Jitter // Backdates the createdAt of like and repost records; nil means none
+
+	// NoLike never likes the post, only reposting or quoting it.
+	NoLike bool
+
+	// QuoteText, when set, replaces the repost with a quote post of the post with this text.
+	// The quote is counted and recorded as the repost would have been. Quoted tells that the
+	// authenticated account already quoted the post, which the viewer state does not.
+	QuoteText string
+	Quoted    bool
+
+	// SelfLabels are the self-label values attached to the quote posts, e.g. !no-unauthenticated.
+	SelfLabels []string
+}
+
+// SelfLabelValues are the values accepted as self-labels of quote posts.
+var SelfLabelValues = []string{"!no-unauthenticated", "porn", "sexual", "nudity", "graphic-media"}
+
+// PendingActions returns how many writes ProcessPostActions would perform for post with opts.
+func PendingActions(post *bsky.FeedDefs_PostView, opts ActionOptions) int {
+	like, repost := pendingWrites(post, opts)
+	n := 0
+	if like {
+		n++
+	}
+	if repost {
+		n++
+	}
+	return n
+}
+
+// pendingWrites reports whether ProcessPostActions would like and repost (or quote) post with opts.
+func pendingWrites(post *bsky.FeedDefs_PostView, opts ActionOptions) (like, repost bool) {
+	alreadyLiked := post.Viewer != nil && post.Viewer.Like != nil
+	alreadyReposted := post.Viewer != nil && post.Viewer.Repost != nil
+	if opts.QuoteText != "" {
+		alreadyReposted = opts.Quoted
+	}
+	return !alreadyLiked && !opts.NoLike, !opts.LikeOnly && !alreadyReposted && (alreadyLiked || opts.NoLike || !opts.RepostRequiresPriorLike)
+}
+
+// writeRepo returns repo, or the authenticated account's DID when repo is empty.
+func writeRepo(xrpcc *xrpc.Client, repo string) string {
+	if repo != "" {
+		return repo
+	}
+	return xrpcc.Auth.Did
+}
+
+// createRecord calls com.atproto.repo.createRecord with input. Once the server has accepted
+// the write, a response that cannot be fully decoded (e.g. because the generated types lag
+// the live lexicon) is only logged: the record exists, so the action succeeded.
+func createRecord(ctx context.Context, xrpcc *xrpc.Client, input any) (*atproto.RepoCreateRecord_Output, error) {
+	var body bytes.Buffer
+	if err := xrpcc.LexDo(ctx, xrpc.Procedure, "application/json", "com.atproto.repo.createRecord", nil, input, &body); err != nil {
+		return nil, err
+	}
+	out := &atproto.RepoCreateRecord_Output{}
+	if err := json.Unmarshal(body.Bytes(), out); err != nil {
+		slog.Debug("Record created but the response could not be decoded", "error", err, "response", body.String())
+	} else if out.Uri == "" || out.Cid == "" {
+		slog.Debug("Record created but the response is partial", "recordUri", out.Uri, "recordCid", out.Cid)
+	}
+	return out, nil
+}
+
+// PostText creates a post with the given text in repo, or in the authenticated account's repo when repo is empty.
+func PostText(ctx context.Context, xrpcc *xrpc.Client, repo, text string, isDryRun bool) error {
+	if isDryRun {
+		slog.Info("DRY RUN: Would have posted", "text", text)
+		return nil
+	}
+
+	record := &bsky.FeedPost{
+		Text:      text,
+		CreatedAt: FormatTimestamp(time.Now()),
+	}
+
+	out, err := createRecord(ctx, xrpcc, &atproto.RepoCreateRecord_Input{
+		Repo:       writeRepo(xrpcc, repo),
+		Collection: "app.bsky.feed.post",
+		Record:     &util.LexiconTypeDecoder{Val: record},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create post: %w", err)
+	}
+	slog.Info("Successfully posted", "postUri", out.Uri, "text", text)
+	return nil
+}
+
+// PinPost sets the post as the pinned post of the profile of repo, or of the authenticated
+// account when repo is empty. The profile record is read, updated and written back with a
+// swap on its CID, so fields unknown to this program are kept and a concurrent edit makes
+// the write fail instead of being overwritten.
+func PinPost(ctx context.Context, xrpcc *xrpc.Client, repo, uri, cid string, isDryRun bool) error {
+	if isDryRun {
+		slog.Info("DRY RUN: Would have pinned post on profile", "postUri", uri)
+		return nil
+	}
+
+	repo = writeRepo(xrpcc, repo)
+	var current struct {
+		Cid   *string        `json:"cid"`
+		Value map[string]any `json:"value"`
+	}
+	params := map[string]any{"repo": repo, "collection": "app.bsky.actor.profile", "rkey": "self"}
+	err := xrpcc.LexDo(ctx, xrpc.Query, "", "com.atproto.repo.getRecord", params, nil, &current)
+	if err != nil && XRPCErrorName(err) != "RecordNotFound" {
+		return fmt.Errorf("failed to read profile to pin post URI %s: %w", uri, err)
+	}
+	record := current.Value
+	if record == nil {
+		record = map[string]any{"$type": "app.bsky.actor.profile"}
+	}
+	record["pinnedPost"] = atproto.RepoStrongRef{Cid: cid, Uri: uri}
+
+	input := map[string]any{
+		"repo":       repo,
+		"collection": "app.bsky.actor.profile",
+		"rkey":       "self",
+		"record":     record,
+	}
+	if current.Cid != nil {
+		input["swapRecord"] = *current.Cid
+	}
+	if err := xrpcc.LexDo(ctx, xrpc.Procedure, "application/json", "com.atproto.repo.putRecord", nil, input, nil); err != nil {
+		return fmt.Errorf("failed to pin post URI %s: %w", uri, err)
+	}
+	slog.Info("Successfully pinned post on profile", "postUri", uri)
+	return nil
+}
+
+// LikePost performs the like action for a given post and returns the URI of the like record.
+// It takes an additional isDryRun boolean to determine if the action should be skipped, in which
+// case no record is created and the URI is empty.
+// The record is written to repo, or to the authenticated account's repo when repo is empty,
+// with createdAt as its creation time.
+func LikePost(ctx context.Context, xrpcc *xrpc.Client, repo, uri, cid string, createdAt time.Time, isDryRun bool) (string, error) {
+	if isDryRun {
+		slog.Info("DRY RUN: Would have liked post", "postUri", uri)
+		return "", nil
+	}
+
+	record := &bsky.FeedLike{
+		Subject: &atproto.RepoStrongRef{
+			Cid: cid,
+			Uri: uri,
+		},
+		CreatedAt: FormatTimestamp(createdAt),
+	}
+
+	out, err := createRecord(ctx, xrpcc, &atproto.RepoCreateRecord_Input{
+		Repo:       writeRepo(xrpcc, repo),
+		Collection: "app.bsky.feed.like",
+		Record:     &util.LexiconTypeDecoder{Val: record},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to like post URI %s: %w", uri, err)
+	}
+	slog.Info("Successfully liked post", "postUri", uri, "recordUri", out.Uri)
+	return out.Uri, nil
+}
+
+// RepostPost performs the repost action for a given post and returns the URI of the repost record.
+// It takes an additional isDryRun boolean to determine if the action should be skipped, in which
+// case no record is created and the URI is empty.
+// The record is written to repo, or to the authenticated account's repo when repo is empty,
+// with createdAt as its creation time, and credits the via feed generator when it is not nil.
+func RepostPost(ctx context.Context, xrpcc *xrpc.Client, repo, uri, cid string, via *atproto.RepoStrongRef, createdAt time.Time, isDryRun bool) (string, error) {
+	if isDryRun {
+		slog.Info("DRY RUN: Would have reposted post", "postUri", uri)
+		return "", nil
+	}
+
+	record := &bsky.FeedRepost{
+		Subject: &atproto.RepoStrongRef{
+			Cid: cid,
+			Uri: uri,
+		},
+		Via:       via,
+		CreatedAt: FormatTimestamp(createdAt),
+	}
+
+	out, err := createRecord(ctx, xrpcc, &atproto.RepoCreateRecord_Input{
+		Repo:       writeRepo(xrpcc, repo),
+		Collection: "app.bsky.feed.repost",
+		Record:     &util.LexiconTypeDecoder{Val: record},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to repost post URI %s: %w", uri, err)
+	}
+	slog.Info("Successfully reposted post", "postUri", uri, "recordUri", out.Uri)
+	return out.Uri, nil
+}
+
+// FeedGeneratorRef returns a strong ref to the feed generator record at uri, suitable for
+// the via field of like and repost records.
+func FeedGeneratorRef(ctx context.Context, xrpcc *xrpc.Client, uri string) (*atproto.RepoStrongRef, error) {
+	out, err := bsky.FeedGetFeedGenerator(ctx, xrpcc, uri)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get feed generator %s: %w", uri, err)
+	}
+	if out.View == nil || out.View.Cid == "" {
+		return nil, fmt.Errorf("failed to get feed generator %s: no record CID in the response", uri)
+	}
+	return &atproto.RepoStrongRef{Uri: out.View.Uri, Cid: out.View.Cid}, nil
+}
+
+// CuratePost creates a record in collection referencing the post by strong ref, so that a feed generator can pick it up.
+// The record has the shape {"$type": collection, "subject": {"uri", "cid"}, "createdAt"}.
+// It takes an additional isDryRun boolean to determine if the action should be skipped.
+func CuratePost(ctx context.Context, xrpcc *xrpc.Client, repo, collection, uri, cid string, isDryRun bool) error {
+	if isDryRun {
+		slog.Info("DRY RUN: Would have recorded post in curation collection", "postUri", uri, "collection", collection)
+		return nil
+	}
+
+	// The collection is user-defined, so there is no generated record type to wrap in a LexiconTypeDecoder;
+	// the createRecord call is issued directly with a plain JSON body instead.
+	input := map[string]any{
+		"repo":       writeRepo(xrpcc, repo),
+		"collection": collection,
+		"record": map[string]any{
+			"$type":     collection,
+			"subject":   atproto.RepoStrongRef{Cid: cid, Uri: uri},
+			"createdAt": FormatTimestamp(time.Now()),
+		},
+	}
+	out, err := createRecord(ctx, xrpcc, input)
+	if err != nil {
+		return fmt.Errorf("failed to record post URI %s in collection %s: %w", uri, collection, err)
+	}
+	slog.Info("Successfully recorded post in curation collection", "postUri", uri, "collection", collection, "recordUri", out.Uri)
+	return nil
+}
+
+// PostOutcome reports what ProcessPostActions did to a post.
+type PostOutcome struct {
+	Liked     bool   // The post was liked, or would have been in dry-run mode
+	Reposted  bool   // The post was reposted, or would have been in dry-run mode
+	LikeURI   string // URI of the like record created; empty in dry-run mode
+	RepostURI string // URI of the repost record, or of the quote post replacing it; empty in dry-run mode
+}
+
+// maxPostGraphemes is the longest text, in graphemes, a post record may have.
+const maxPostGraphemes = 300
+
+// externalTitle returns the title of the link card embedded in post, alone or next to a quoted
+// record, cut to fit a post, or "" when the post has no link card.
+func externalTitle(post *bsky.FeedDefs_PostView) string {
+	var title string
+	if record := postRecord(post); record != nil && record.Embed != nil {
+		external := record.Embed.EmbedExternal
+		if rwm := record.Embed.EmbedRecordWithMedia; rwm != nil && rwm.Media != nil {
+			external = rwm.Media.EmbedExternal
+		}
+		if external != nil && external.External != nil {
+			title = external.External.Title
+		}
+	}
+	if embed := post.Embed; title == "" && embed != nil {
+		view := embed.EmbedExternal_View
+		if rwm := embed.EmbedRecordWithMedia_View; rwm != nil && rwm.Media != nil {
+			view = rwm.Media.EmbedExternal_View
+		}
+		if view != nil && view.External != nil {
+			title = view.External.Title
+		}
+	}
+	title = strings.TrimSpace(title)
+	graphemes := uniseg.NewGraphemes(title)
+	for n := 0; graphemes.Next(); n++ {
+		if n == maxPostGraphemes {
+			start, _ := graphemes.Positions()
+			return strings.TrimSpace(title[:start])
+		}
+	}
+	return title
+}
+
+// QuotePost creates a post with text quoting the given post and returns the URI of the new post.
+// It takes an additional isDryRun boolean to determine if the action should be skipped, in which
+// case no record is created and the URI is empty.
+// The post is written to repo, or to the authenticated account's repo when repo is empty,
+// with createdAt as its creation time, and carries labels as self-labels when not empty.
+func QuotePost(ctx context.Context, xrpcc *xrpc.Client, repo, uri, cid, text string, labels []string, createdAt time.Time, isDryRun bool) (string, error) {
+	if isDryRun {
+		slog.Info("DRY RUN: Would have quoted post", "postUri", uri, "text", text, "selfLabels", labels)
+		return "", nil
+	}
+
+	record := &bsky.FeedPost{
+		Text: text,
+		Embed: &bsky.FeedPost_Embed{
+			EmbedRecord: &bsky.EmbedRecord{
+				Record: &atproto.RepoStrongRef{
+					Cid: cid,
+					Uri: uri,
+				},
+			},
+		},
+		CreatedAt: FormatTimestamp(createdAt),
+	}
+	if len(labels) > 0 {
+		selfLabels := &atproto.LabelDefs_SelfLabels{}
+		for _, v := range labels {
+			selfLabels.Values = append(selfLabels.Values, &atproto.LabelDefs_SelfLabel{Val: v})
+		}
+		record.Labels = &bsky.FeedPost_Labels{LabelDefs_SelfLabels: selfLabels}
+	}
+
+	out, err := createRecord(ctx, xrpcc, &atproto.RepoCreateRecord_Input{
+		Repo:       writeRepo(xrpcc, repo),
+		Collection: "app.bsky.feed.post",
+		Record:     &util.LexiconTypeDecoder{Val: record},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to quote post URI %s: %w", uri, err)
+	}
+	slog.Info("Successfully quoted post", "postUri", uri, "recordUri", out.Uri)
+	return out.Uri, nil
+}
+
+// ProcessPostActions likes and/or reposts the given post if needed.
+// It reports which of the two actions were performed (or would have been, in dry-run mode)
+// and the records they created.
+// When opts.Parallel is set the like and repost are issued concurrently. Errors from either action
+// are logged and returned joined together.
+func ProcessPostActions(ctx context.Context, xrpcc *xrpc.Client, post *bsky.FeedDefs_PostView, opts ActionOptions) (outcome PostOutcome, err error) {
+	ctx, span := startSpan(ctx, "action",
+		attribute.String("post.uri", post.Uri),
+		attribute.String("post.cid", post.Cid),
+		attribute.String("post.author_did", post.Author.Did),
+		attribute.Bool("dry_run", opts.DryRun),
+	)
+	defer func() {
+		span.SetAttributes(attribute.Bool("action.liked", outcome.Liked), attribute.Bool("action.reposted", outcome.Reposted))
+		endSpan(span, err)
+	}()
+
+	alreadyLiked := post.Viewer != nil && post.Viewer.Like != nil
+	alreadyReposted := post.Viewer != nil && post.Viewer.Repost != nil
+
+	slog.Info("Found eligible post to action",
+		"postUri", post.Uri,
+		"authorDisplayName", post.Author.DisplayName,
+		"alreadyLiked", alreadyLiked,
+		"alreadyReposted", alreadyReposted,
+	)
+
+	var likeErr, repostErr error
+	like := func() error {
+		if alreadyLiked {
+			slog.Debug("Post already liked, skipping like action", "postUri", post.Uri)
+			return nil
+		}
+		if opts.NoLike {
+			slog.Debug("Target policy excludes likes, skipping like action", "postUri", post.Uri)
+			return nil
+		}
+		if !opts.DryRun {
+			if likeErr = opts.Limiter.Wait(ctx); likeErr != nil {
+				return likeErr
+			}
+		}
+		writeCtx, cancel := withTimeout(ctx, opts.WriteTimeout)
+		outcome.LikeURI, likeErr = LikePost(writeCtx, xrpcc, opts.Repo, post.Uri, post.Cid, opts.Jitter.CreatedAt(time.Now()), opts.DryRun)
+		cancel()
+		if likeErr != nil {
+			slog.Error("Error liking post", append([]any{"postUri", post.Uri}, ErrorAttrs(likeErr)...)...)
+		} else {
+			outcome.Liked = true
+		}
+		return likeErr
+	}
+	repost := func() error {
+		if alreadyReposted && opts.QuoteText == "" {
+			slog.Debug("Post already reposted, skipping repost action", "postUri", post.Uri)
+			return nil
+		}
+		if opts.Quoted && opts.QuoteText != "" {
+			slog.Debug("Post already quoted, skipping quote action", "postUri", post.Uri)
+			return nil
+		}
+		if opts.LikeOnly {
+			slog.Debug("Like-only post, skipping repost action", "postUri", post.Uri)
+			return nil
+		}
+		if opts.RepostRequiresPriorLike && !alreadyLiked && !opts.NoLike {
+			slog.Info("Post not liked before this run, deferring repost to a later run", "postUri", post.Uri)
+			return nil
+		}
+		if !opts.DryRun {
+			if repostErr = opts.Limiter.Wait(ctx); repostErr != nil {
+				return repostErr
+			}
+		}
+		if opts.QuoteText != "" {
+			writeCtx, cancel := withTimeout(ctx, opts.WriteTimeout)
+			outcome.RepostURI, repostErr = QuotePost(writeCtx, xrpcc, opts.Repo, post.Uri, post.Cid, opts.QuoteText, opts.SelfLabels, opts.Jitter.CreatedAt(time.Now()), opts.DryRun)
+			cancel()
+			if repostErr != nil {
+				slog.Error("Error quoting post", append([]any{"postUri", post.Uri}, ErrorAttrs(repostErr)...)...)
+				return repostErr
+			}
+			outcome.Reposted = true
+			return nil
+		}
+		writeCtx, cancel := withTimeout(ctx, opts.WriteTimeout)
+		outcome.RepostURI, repostErr = RepostPost(writeCtx, xrpcc, opts.Repo, post.Uri, post.Cid, opts.Via, opts.Jitter.CreatedAt(time.Now()), opts.DryRun)
+		cancel()
+		if repostErr != nil {
+			slog.Error("Error reposting post", append([]any{"postUri", post.Uri}, ErrorAttrs(repostErr)...)...)
+			return repostErr
+		}
+		outcome.Reposted = true
+		if opts.CurateCollection != "" {
+			if !opts.DryRun {
+				if repostErr = opts.Limiter.Wait(ctx); repostErr != nil {
+					return repostErr
+				}
+			}
+			writeCtx, cancel := withTimeout(ctx, opts.WriteTimeout)
+			repostErr = CuratePost(writeCtx, xrpcc, opts.Repo, opts.CurateCollection, post.Uri, post.Cid, opts.DryRun)
+			cancel()
+			if repostErr != nil {
+				slog.Error("Error recording post in curation collection", append([]any{"postUri", post.Uri}, ErrorAttrs(repostErr)...)...)
+			}
+		}
+		return repostErr
+	}
+
+	if opts.Parallel {
+		// A plain Group rather than WithContext: a failed like must not cancel the repost.
+		var g errgroup.Group
+		g.Go(like)
+		g.Go(repost)
+		_ = g.Wait() // Both errors are joined below; Wait only reports the first.
+	} else {
+		_ = like()
+		_ = repost()
+	}
+
+	slog.Info("Actioned eligible post.", "postUri", post.Uri)
+	return outcome, errors.Join(likeErr, repostErr)
+}
@@ -0,0 +1,132 @@
+package reposter
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+)
+
+func TestProcessPostActionsToleratesPartialCreateRecordResponses(t *testing.T) {
+	bodies := map[string]string{
+		"empty":       "",
+		"empty JSON":  "{}",
+		"no cid":      `{"uri":"at://did:plc:me/app.bsky.feed.like/1"}`,
+		"not JSON":    "<html>ok</html>",
+		"unknown key": `{"uri":"at://did:plc:me/app.bsky.feed.like/1","cid":"bafyrecord","commit":{"cid":"bafycommit","rev":"1"},"validationStatus":"valid","future":[1,2]}`,
+	}
+	for name, body := range bodies {
+		t.Run(name, func(t *testing.T) {
+			pds := newFakePDS(t)
+			pds.CreateRecordBody = &body
+			post := testPost(testTarget, 1)
+
+			outcome, err := ProcessPostActions(context.Background(), pds.client(), post, ActionOptions{})
+			if err != nil {
+				t.Fatalf("ProcessPostActions returned error: %v", err)
+			}
+			if !outcome.Liked || !outcome.Reposted {
+				t.Errorf("ProcessPostActions liked %t and reposted %t, want both", outcome.Liked, outcome.Reposted)
+			}
+			likes, reposts := actionedSubjects(pds.records())
+			if len(likes) != 1 || len(reposts) != 1 {
+				t.Errorf("ProcessPostActions wrote %d likes and %d reposts, want 1 and 1", len(likes), len(reposts))
+			}
+		})
+	}
+}
+
+func TestQuotePostSelfLabels(t *testing.T) {
+	pds := newFakePDS(t)
+	post := testPost(testTarget, 1)
+	labels := []string{"!no-unauthenticated", "graphic-media"}
+	if _, err := QuotePost(context.Background(), pds.client(), "", post.Uri, post.Cid, "look", labels, testEpoch, false); err != nil {
+		t.Fatalf("QuotePost returned error: %v", err)
+	}
+	if _, err := QuotePost(context.Background(), pds.client(), "", post.Uri, post.Cid, "look", nil, testEpoch, false); err != nil {
+		t.Fatalf("QuotePost returned error: %v", err)
+	}
+
+	records := pds.records()
+	if len(records) != 2 {
+		t.Fatalf("QuotePost created %d records, want 2", len(records))
+	}
+	var labeled, unlabeled bsky.FeedPost
+	if err := json.Unmarshal(records[0].Record, &labeled); err != nil {
+		t.Fatalf("Quote post record does not decode: %v", err)
+	}
+	if err := json.Unmarshal(records[1].Record, &unlabeled); err != nil {
+		t.Fatalf("Quote post record does not decode: %v", err)
+	}
+	var got []string
+	if labeled.Labels != nil && labeled.Labels.LabelDefs_SelfLabels != nil {
+		for _, label := range labeled.Labels.LabelDefs_SelfLabels.Values {
+			got = append(got, label.Val)
+		}
+	}
+	if !slices.Equal(got, labels) {
+		t.Errorf("Quote post self-labels = %v, want %v", got, labels)
+	}
+	if unlabeled.Labels != nil {
+		t.Errorf("Quote post without self-labels carries labels %+v", unlabeled.Labels)
+	}
+}
+
+func TestValidateSelfLabels(t *testing.T) {
+	cfg := Config{Handle: "me.test", Password: "password", TargetDID: testTarget, QuoteText: "look"}
+	cfg.SelfLabels = []string{"!no-unauthenticated", "porn"}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate returned error for allowlisted self-labels: %v", err)
+	}
+	cfg.SelfLabels = []string{"spam"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate returned no error for a self-label outside the allowlist")
+	}
+	cfg.SelfLabels, cfg.QuoteText = []string{"porn"}, ""
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate returned no error for self-labels without quoting")
+	}
+}
+
+// withLinkCard embeds in post's record a link card titled title.
+func withLinkCard(post *bsky.FeedDefs_PostView, title string) *bsky.FeedDefs_PostView {
+	postRecord(post).Embed = &bsky.FeedPost_Embed{EmbedExternal: &bsky.EmbedExternal{
+		External: &bsky.EmbedExternal_External{Uri: "https://example.com/", Title: title},
+	}}
+	return post
+}
+
+func TestExternalTitle(t *testing.T) {
+	withView := testPost(testTarget, 3)
+	withView.Embed = &bsky.FeedDefs_PostView_Embed{EmbedExternal_View: &bsky.EmbedExternal_View{
+		External: &bsky.EmbedExternal_ViewExternal{Uri: "https://example.com/", Title: "From the view"},
+	}}
+	withQuote := testPost(testTarget, 4)
+	postRecord(withQuote).Embed = &bsky.FeedPost_Embed{EmbedRecordWithMedia: &bsky.EmbedRecordWithMedia{
+		Media: &bsky.EmbedRecordWithMedia_Media{EmbedExternal: &bsky.EmbedExternal{
+			External: &bsky.EmbedExternal_External{Uri: "https://example.com/", Title: "Next to a quote"},
+		}},
+	}}
+	noExternal := testPost(testTarget, 5)
+	postRecord(noExternal).Embed = &bsky.FeedPost_Embed{EmbedExternal: &bsky.EmbedExternal{}}
+	tests := []struct {
+		name string
+		post *bsky.FeedDefs_PostView
+		want string
+	}{
+		{"no embed", testPost(testTarget, 1), ""},
+		{"record embed", withLinkCard(testPost(testTarget, 2), "  A link\n"), "A link"},
+		{"view embed", withView, "From the view"},
+		{"record with media", withQuote, "Next to a quote"},
+		{"external without details", noExternal, ""},
+		{"too long", withLinkCard(testPost(testTarget, 6), strings.Repeat("👍🏽", maxPostGraphemes+5)), strings.Repeat("👍🏽", maxPostGraphemes)},
+	}
+	for _, tt := range tests {
+		if got := externalTitle(tt.post); got != tt.want {
+			t.Errorf("externalTitle(post with %s) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package reposter
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/xrpc"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// AuthenticateAndInit creates a session with Bluesky, stores its credentials on xrpcc and returns the session info.
+// If the server requires a two-factor token and authFactorToken is set, session creation is retried with it.
+func AuthenticateAndInit(ctx context.Context, xrpcc *xrpc.Client, handle, password, authFactorToken string) (*atproto.ServerCreateSession_Output, error) {
+	input := &atproto.ServerCreateSession_Input{
+		Identifier: handle,
+		Password:   password,
+	}
+	session, err := atproto.ServerCreateSession(ctx, xrpcc, input)
+	if err != nil && XRPCErrorName(err) == "AuthFactorTokenRequired" && authFactorToken != "" {
+		slog.Info("Two-factor token required, retrying session creation with the provided token")
+		input.AuthFactorToken = &authFactorToken
+		session, err = atproto.ServerCreateSession(ctx, xrpcc, input)
+	}
+	if err != nil {
+		return nil, err
+	}
+	xrpcc.Auth = &xrpc.AuthInfo{
+		AccessJwt:  session.AccessJwt,
+		RefreshJwt: session.RefreshJwt,
+		Did:        session.Did,
+		Handle:     session.Handle,
+	}
+	return session, nil
+}
+
+// clientOptions returns the options of the authenticated client described by cfg.
+func clientOptions(cfg Config, counter *CallCounter) (ClientOptions, error) {
+	clientOpts := ClientOptions{Host: cfg.PDSHost, UserAgent: cfg.UserAgent, Counter: counter, ConnectTimeout: cfg.ConnectTimeout}
+	// Per-call contexts bound reads and writes instead, so that they can exceed the client's 30s.
+	clientOpts.NoClientTimeout = cfg.ReadTimeout > 0 || cfg.WriteTimeout > 0
+	var err error
+	if cfg.CAFile != "" || cfg.InsecureSkipVerify {
+		if cfg.InsecureSkipVerify {
+			slog.Warn("TLS CERTIFICATE VERIFICATION IS DISABLED. Connections can be intercepted; never use --insecure-skip-verify outside of testing.")
+		}
+		clientOpts.TLSConfig, err = LoadTLSConfig(cfg.CAFile, cfg.InsecureSkipVerify)
+		if err != nil {
+			return clientOpts, err
+		}
+	}
+	if cfg.Proxy != "" {
+		clientOpts.Proxy, err = ParseProxyURL(cfg.Proxy)
+		if err != nil {
+			return clientOpts, err
+		}
+	}
+	if cfg.OAuth != nil {
+		clientOpts.DPoPKey, err = LoadECKey(cfg.OAuth.DPoPKeyFile)
+		if err != nil {
+			return clientOpts, err
+		}
+	}
+	return clientOpts, nil
+}
+
+// connect creates a client from clientOpts and authenticates it with the OAuth or app
+// password credentials of cfg, returning the account's DID and handle.
+// With cfg.StartupWait, authentication failing because the network is not ready yet is
+// retried with backoff until that much time has passed.
+func connect(ctx context.Context, cfg Config, clientOpts ClientOptions) (xrpcc *xrpc.Client, did, handle string, err error) {
+	ctx, span := startSpan(ctx, "auth", attribute.Bool("auth.oauth", cfg.OAuth != nil))
+	defer func() {
+		span.SetAttributes(attribute.String("auth.did", did))
+		endSpan(span, err)
+	}()
+	xrpcc = NewXRPCClient(clientOpts)
+	deadline := time.Now().Add(cfg.StartupWait)
+	for delay := time.Second; ; delay = min(2*delay, 30*time.Second) {
+		authCtx, cancel := withTimeout(ctx, cfg.ConnectTimeout)
+		did, handle, err = authenticate(authCtx, cfg, xrpcc)
+		cancel()
+		if err == nil {
+			break
+		}
+		remaining := time.Until(deadline)
+		if !IsNetworkNotReady(err) || remaining <= 0 {
+			return nil, "", "", err
+		}
+		slog.Warn("Network not ready yet, waiting before authenticating again",
+			append([]any{"delay", min(delay, remaining), "startupWaitLeft", remaining}, ErrorAttrs(err)...)...,
+		)
+		if err := sleepCtx(ctx, min(delay, remaining)); err != nil {
+			return nil, "", "", err
+		}
+	}
+	slog.Info("Successfully authenticated",
+		"handle", handle,
+		"did", did,
+		"oauth", cfg.OAuth != nil,
+	)
+	return xrpcc, did, handle, nil
+}
+
+// authenticate authenticates xrpcc with the OAuth or app password credentials of cfg.
+func authenticate(ctx context.Context, cfg Config, xrpcc *xrpc.Client) (did, handle string, err error) {
+	if cfg.OAuth != nil {
+		session, err := AuthenticateOAuth(ctx, xrpcc, *cfg.OAuth)
+		if err != nil {
+			return "", "", fmt.Errorf("OAuth authentication failed: %w", err)
+		}
+		return session.Did, session.Handle, nil
+	}
+	if cfg.SessionFile != "" {
+		if did, handle, ok := resumeSession(ctx, cfg, xrpcc); ok {
+			saveSessionFile(cfg, xrpcc)
+			return did, handle, nil
+		}
+	}
+	var session *atproto.ServerCreateSession_Output
+	err = Retry(ctx, "createSession", cfg.AuthAttempts, 2*time.Second, RetryableErrors(cfg.RetryableErrors), func() error {
+		var err error
+		session, err = AuthenticateAndInit(ctx, xrpcc, cfg.Handle, cfg.Password, cfg.AuthFactorToken)
+		return err
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("authentication failed: %w", err)
+	}
+	if cfg.SessionFile != "" {
+		saveSessionFile(cfg, xrpcc)
+	}
+	return session.Did, session.Handle, nil
+}
+
+// saveSessionFile caches the session of xrpcc in cfg.SessionFile; a failure is only logged,
+// the next run then creating a new session.
+func saveSessionFile(cfg Config, xrpcc *xrpc.Client) {
+	if err := saveSession(cfg.SessionFile, cfg.SessionKey, xrpcc.Auth); err != nil {
+		slog.Warn("Failed to cache session", "sessionFile", cfg.SessionFile, "error", err)
+	}
+}
@@ -0,0 +1,172 @@
+package reposter
+
+import (
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/util"
+	"github.com/bluesky-social/indigo/xrpc"
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+// ClientOptions configures the XRPC client created by NewXRPCClient.
+type ClientOptions struct {
+	Host      string       // PDS URL; defaults to BlueskyPDS
+	UserAgent string       // User-Agent header sent with every request; empty keeps the library default
+	Counter   *CallCounter // When set, every XRPC call made through the client is counted
+	TLSConfig *tls.Config  // When set, replaces the default TLS configuration (system roots, verification on)
+	Proxy     *url.URL     // When set, every request goes through this proxy instead of the one from HTTP(S)_PROXY/NO_PROXY
+
+	// DPoPKey, when set, is used to add a DPoP proof to every request, as required by OAuth-issued tokens.
+	DPoPKey *ecdsa.PrivateKey
+
+	ConnectTimeout  time.Duration // When positive, bounds dialing and the TLS handshake of every connection
+	NoClientTimeout bool          // Remove the 30s limit on each request, leaving timeouts to the callers' contexts
+}
+
+// NewXRPCClient returns an unauthenticated XRPC client configured from opts.
+func NewXRPCClient(opts ClientOptions) *xrpc.Client {
+	host := opts.Host
+	if host == "" {
+		host = BlueskyPDS
+	}
+	httpClient := util.RobustHTTPClient()
+	if opts.NoClientTimeout {
+		httpClient.Timeout = 0
+	}
+	if opts.TLSConfig != nil || opts.Proxy != nil || opts.ConnectTimeout > 0 {
+		// DefaultPooledTransport honours the proxy environment variables, like the default base transport.
+		base := cleanhttp.DefaultPooledTransport()
+		if opts.TLSConfig != nil {
+			base.TLSClientConfig = opts.TLSConfig
+		}
+		if opts.Proxy != nil {
+			base.Proxy = http.ProxyURL(opts.Proxy)
+		}
+		if opts.ConnectTimeout > 0 {
+			base.DialContext = (&net.Dialer{Timeout: opts.ConnectTimeout, KeepAlive: 30 * time.Second}).DialContext
+			base.TLSHandshakeTimeout = opts.ConnectTimeout
+		}
+		setBaseTransport(httpClient, base)
+	}
+	if opts.DPoPKey != nil {
+		// Installed below the retry layer so every attempt carries a fresh proof.
+		setBaseTransport(httpClient, &dpopTransport{next: baseTransport(httpClient), key: opts.DPoPKey})
+	}
+	if opts.Counter != nil {
+		httpClient.Transport = &countingTransport{next: httpClient.Transport, counter: opts.Counter}
+	}
+	xrpcc := &xrpc.Client{Host: host, Client: httpClient}
+	if opts.UserAgent != "" {
+		xrpcc.UserAgent = &opts.UserAgent
+	}
+	return xrpcc
+}
+
+// setBaseTransport replaces the transport performing the actual requests of a util.RobustHTTPClient,
+// keeping its retry behaviour.
+func setBaseTransport(c *http.Client, base http.RoundTripper) {
+	if rt, ok := c.Transport.(*retryablehttp.RoundTripper); ok {
+		rt.Client.HTTPClient.Transport = base
+		return
+	}
+	c.Transport = base
+}
+
+// baseTransport returns the transport performing the actual requests of a util.RobustHTTPClient.
+func baseTransport(c *http.Client) http.RoundTripper {
+	if rt, ok := c.Transport.(*retryablehttp.RoundTripper); ok && rt.Client.HTTPClient.Transport != nil {
+		return rt.Client.HTTPClient.Transport
+	}
+	if c.Transport != nil {
+		return c.Transport
+	}
+	return http.DefaultTransport
+}
+
+// ParseProxyURL parses and validates a proxy URL such as http://proxy.example:3128.
+func ParseProxyURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL %q: %w", raw, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("invalid proxy URL %q, expected an http, https or socks5 scheme", raw)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy URL %q, missing host", raw)
+	}
+	return u, nil
+}
+
+// LoadTLSConfig builds a TLS configuration trusting the system roots plus the PEM certificates in caFile, if set.
+// insecureSkipVerify disables certificate verification entirely and must only be used for testing.
+func LoadTLSConfig(caFile string, insecureSkipVerify bool) (*tls.Config, error) {
+	cfg := &tls.Config{InsecureSkipVerify: insecureSkipVerify}
+	if caFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file %s: %w", caFile, err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates found in CA file %s", caFile)
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
+
+// CallCounter counts XRPC calls by method NSID (e.g. "com.atproto.repo.createRecord").
+// It is safe for concurrent use.
+type CallCounter struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// Add records one call to method.
+func (c *CallCounter) Add(method string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts == nil {
+		c.counts = make(map[string]int)
+	}
+	c.counts[method]++
+}
+
+// Counts returns a snapshot of the calls recorded so far.
+func (c *CallCounter) Counts() map[string]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return maps.Clone(c.counts)
+}
+
+// countingTransport is an http.RoundTripper that counts XRPC calls before delegating to next.
+// Retries performed by next are not counted separately.
+type countingTransport struct {
+	next    http.RoundTripper
+	counter *CallCounter
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if method, ok := strings.CutPrefix(req.URL.Path, "/xrpc/"); ok {
+		t.counter.Add(method)
+	}
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,106 @@
+package reposter
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/lex/util"
+)
+
+// dumpedPost is the representation of a collected post written by DumpFeed.
+type dumpedPost struct {
+	URI          string `json:"uri"`
+	CID          string `json:"cid"`
+	AuthorDID    string `json:"authorDid"`
+	AuthorHandle string `json:"authorHandle"`
+	IndexedAt    string `json:"indexedAt"`
+	CreatedAt    string `json:"createdAt,omitempty"`
+	Text         string `json:"text,omitempty"`
+	LikeCount    int64  `json:"likeCount"`
+	RepostCount  int64  `json:"repostCount"`
+	ReplyCount   int64  `json:"replyCount"`
+	ViewerLike   string `json:"viewerLike,omitempty"`   // URI of our like record, if any
+	ViewerRepost string `json:"viewerRepost,omitempty"` // URI of our repost record, if any
+
+	// Record is the full post record, so that replies, embeds and other fields the filters
+	// look at survive LoadFeed. Dumps written before it was added lack it.
+	Record *util.LexiconTypeDecoder `json:"record,omitempty"`
+}
+
+// DumpFeed writes posts to path as a JSON array sorted by indexedAt then URI,
+// so that the same feed always produces the same file.
+func DumpFeed(path string, posts []*bsky.FeedDefs_PostView) error {
+	dump := make([]dumpedPost, 0, len(posts))
+	for _, post := range posts {
+		d := dumpedPost{
+			URI:         post.Uri,
+			CID:         post.Cid,
+			IndexedAt:   post.IndexedAt,
+			Record:      post.Record,
+			LikeCount:   countOrZero(post.LikeCount),
+			RepostCount: countOrZero(post.RepostCount),
+			ReplyCount:  countOrZero(post.ReplyCount),
+		}
+		if post.Author != nil {
+			d.AuthorDID, d.AuthorHandle = post.Author.Did, post.Author.Handle
+		}
+		if record := postRecord(post); record != nil {
+			d.CreatedAt, d.Text = record.CreatedAt, record.Text
+		}
+		if post.Viewer != nil {
+			if post.Viewer.Like != nil {
+				d.ViewerLike = *post.Viewer.Like
+			}
+			if post.Viewer.Repost != nil {
+				d.ViewerRepost = *post.Viewer.Repost
+			}
+		}
+		dump = append(dump, d)
+	}
+	slices.SortFunc(dump, func(a, b dumpedPost) int {
+		return cmp.Or(cmp.Compare(a.IndexedAt, b.IndexedAt), cmp.Compare(a.URI, b.URI))
+	})
+	return writeFileAtomic(path, dump)
+}
+
+// LoadFeed reads a feed written by DumpFeed back into post views. Posts of dumps lacking the
+// full record get a record holding only their text and creation time.
+func LoadFeed(path string) ([]*bsky.FeedDefs_PostView, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read feed dump %s: %w", path, err)
+	}
+	var dump []dumpedPost
+	if err := json.Unmarshal(data, &dump); err != nil {
+		return nil, fmt.Errorf("failed to parse feed dump %s: %w", path, err)
+	}
+	posts := make([]*bsky.FeedDefs_PostView, 0, len(dump))
+	for _, d := range dump {
+		post := &bsky.FeedDefs_PostView{
+			Uri:         d.URI,
+			Cid:         d.CID,
+			Author:      &bsky.ActorDefs_ProfileViewBasic{Did: d.AuthorDID, Handle: d.AuthorHandle},
+			IndexedAt:   d.IndexedAt,
+			Record:      d.Record,
+			LikeCount:   &d.LikeCount,
+			RepostCount: &d.RepostCount,
+			ReplyCount:  &d.ReplyCount,
+			Viewer:      &bsky.FeedDefs_ViewerState{},
+		}
+		if post.Record == nil {
+			post.Record = &util.LexiconTypeDecoder{Val: &bsky.FeedPost{Text: d.Text, CreatedAt: d.CreatedAt}}
+		}
+		if d.ViewerLike != "" {
+			post.Viewer.Like = &d.ViewerLike
+		}
+		if d.ViewerRepost != "" {
+			post.Viewer.Repost = &d.ViewerRepost
+		}
+		posts = append(posts, post)
+	}
+	return posts, nil
+}
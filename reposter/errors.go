@@ -0,0 +1,142 @@
+package reposter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// ErrCircuitOpen is returned by Run when too many consecutive actions failed.
+var ErrCircuitOpen = errors.New("circuit opened")
+
+// Retry calls fn up to attempts times, doubling the delay after each failure starting from baseDelay.
+// It stops early when fn succeeds, when retryable reports the error as permanent, or when ctx is done.
+func Retry(ctx context.Context, operation string, attempts int, baseDelay time.Duration, retryable func(error) bool, fn func() error) error {
+	delay := baseDelay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if attempt >= attempts || !retryable(err) {
+			return err
+		}
+		slog.Warn("Operation failed, retrying",
+			append([]any{"operation", operation, "attempt", attempt, "maxAttempts", attempts, "delay", delay}, ErrorAttrs(err)...)...,
+		)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// DefaultRetryableErrors lists the XRPC error names retried by default: conditions of the
+// server or its upstreams that clear on their own. Errors such as AuthenticationRequired or
+// AuthFactorTokenRequired are left out, since retrying cannot fix them.
+var DefaultRetryableErrors = []string{
+	"RateLimitExceeded",
+	"InternalServerError",
+	"UpstreamFailure",
+	"UpstreamTimeout",
+	"NotEnoughResources",
+}
+
+// RetryableErrors returns a classifier for Retry that retries the errors without an XRPC
+// error name, such as network failures, and the XRPC errors named in names.
+// Errors classified as permanent are logged with the reason.
+func RetryableErrors(names []string) func(error) bool {
+	return func(err error) bool {
+		name := XRPCErrorName(err)
+		if name == "" || slices.Contains(names, name) {
+			return true
+		}
+		slog.Info("Error classified as non-retryable, not retrying",
+			"xrpcError", name,
+			"reason", "error name not in the retryable errors list",
+			"retryableErrors", names,
+		)
+		return false
+	}
+}
+
+// IsNetworkNotReady reports whether err looks like the network is not up yet, as right after
+// boot: a failed DNS lookup, or a connection that was refused, reset or had no route.
+// Such errors are transient, unlike authentication errors returned by the server.
+func IsNetworkNotReady(err error) bool {
+	if XRPCErrorName(err) != "" {
+		return false
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	for _, errno := range []syscall.Errno{syscall.ECONNREFUSED, syscall.ECONNRESET, syscall.ENETUNREACH, syscall.EHOSTUNREACH, syscall.ENETDOWN} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// XRPCErrorName returns the Bluesky error name (e.g. "RateLimitExceeded") carried by err, or "" if none.
+func XRPCErrorName(err error) string {
+	var xerr *xrpc.XRPCError
+	if errors.As(err, &xerr) {
+		return xerr.ErrStr
+	}
+	return ""
+}
+
+// ErrorAttrs returns slog attributes describing err. When err wraps an XRPC error,
+// the Bluesky error name, message and HTTP status are added as separate attributes.
+func ErrorAttrs(err error) []any {
+	attrs := []any{"error", err}
+	var xe *xrpc.Error
+	if errors.As(err, &xe) {
+		attrs = append(attrs, "httpStatus", xe.StatusCode)
+	}
+	var xerr *xrpc.XRPCError
+	if errors.As(err, &xerr) {
+		attrs = append(attrs, "xrpcError", xerr.ErrStr, "xrpcMessage", xerr.Message)
+	}
+	return attrs
+}
+
+// RunError is a non-fatal error that occurred during a run.
+type RunError struct {
+	Stage   string // What was being done: "feed", "action", "pin" or "summary"
+	PostURI string // Post concerned, if any
+	Err     error
+}
+
+func (e RunError) Error() string {
+	if e.PostURI != "" {
+		return fmt.Sprintf("%s %s: %v", e.Stage, e.PostURI, e.Err)
+	}
+	return fmt.Sprintf("%s: %v", e.Stage, e.Err)
+}
+
+func (e RunError) Unwrap() error {
+	return e.Err
+}
+
+// logRunErrors logs a single line counting errs followed by one line per error.
+func logRunErrors(errs []RunError) {
+	if len(errs) == 0 {
+		return
+	}
+	slog.Warn(fmt.Sprintf("Run completed with %d errors", len(errs)), "errorCount", len(errs))
+	for _, e := range errs {
+		slog.Warn("Run error", append([]any{"stage", e.Stage, "postUri", e.PostURI}, ErrorAttrs(e.Err)...)...)
+	}
+}
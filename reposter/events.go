@@ -0,0 +1,52 @@
+package reposter
+
+import (
+	"encoding/json"
+	"maps"
+	"time"
+)
+
+// Event types reported through Config.OnEvent, in the order they occur during a run.
+const (
+	EventAuthOK          = "auth_ok"          // did, handle
+	EventPageFetched     = "page_fetched"     // target, source, cursor, items; query instead of target when source is "search"
+	EventPostSelected    = "post_selected"    // uri, author
+	EventActionPerformed = "action_performed" // uri, action ("like" or "repost"), dryRun
+	EventActionFailed    = "action_failed"    // uri, error
+	EventRunComplete     = "run_complete"     // liked, reposted, skipped, failed, error (only if the run failed)
+)
+
+// Event is a step of a run. It marshals to a single JSON object holding "type", "ts" and
+// the type-specific fields listed next to each event type.
+type Event struct {
+	Type   string
+	Time   time.Time
+	Fields map[string]any
+}
+
+func (e Event) MarshalJSON() ([]byte, error) {
+	m := maps.Clone(e.Fields)
+	if m == nil {
+		m = make(map[string]any, 2)
+	}
+	m["type"] = e.Type
+	m["ts"] = e.Time.UTC().Format(time.RFC3339Nano)
+	return json.Marshal(m)
+}
+
+// eventFunc sends events to a Config.OnEvent callback, doing nothing when it is nil.
+type eventFunc func(Event)
+
+// emit sends an event of type typ with the fields given as alternating keys and values, like slog.
+func (f eventFunc) emit(typ string, kv ...any) {
+	if f == nil {
+		return
+	}
+	fields := make(map[string]any, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		if key, ok := kv[i].(string); ok {
+			fields[key] = kv[i+1]
+		}
+	}
+	f(Event{Type: typ, Time: time.Now(), Fields: fields})
+}
@@ -0,0 +1,382 @@
+package reposter
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/xrpc"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// FeedOptions controls how the target's feed is read.
+type FeedOptions struct {
+	Source string        // SourceAuthor (default) or SourceLikes
+	Filter string        // app.bsky.feed.getAuthorFeed filter, e.g. AuthorFilterAuthorThreads; empty uses the server default
+	Budget time.Duration // Maximum time spent paginating; 0 means no limit
+
+	// ReadClient, when set, is used to fetch feed pages instead of the authenticated client,
+	// e.g. to read from the target's own PDS. It should not carry our credentials; viewer
+	// state is then hydrated separately through the authenticated client.
+	ReadClient *xrpc.Client
+
+	// Stats, when set, is updated as pages are fetched.
+	Stats *FeedStats
+
+	// StopOnRepeatPage ends the scan at the first page that contains no post not already
+	// seen. On very active accounts new posts shift the feed while it is being paginated
+	// and cursors can return overlapping pages; this bounds the scan instead of following
+	// such a cursor deeper.
+	StopOnRepeatPage bool
+
+	// ScanPastActioned keeps paginating past posts already liked and reposted instead of ending
+	// the scan at the first one, to catch older posts whose like or repost was undone. Every page
+	// up to MaxPages, Budget or the end of the feed is then fetched, on every run.
+	ScanPastActioned bool
+
+	// IncludeReposts also yields the posts the target reposted, from the author feed.
+	// The original post is yielded, so it is the one liked and reposted.
+	IncludeReposts bool
+
+	MaxPages  int           // Maximum number of pages fetched per feed; 0 means no limit
+	PageDelay time.Duration // Pause between two page fetches; 0 means none
+
+	// OnEvent, when set, receives an EventPageFetched event for every page fetched.
+	OnEvent func(Event)
+
+	// StartCursor, when set, starts the scan at this cursor instead of at the newest post.
+	StartCursor string
+
+	ReadTimeout time.Duration // When positive, bounds each page fetch, retries included
+
+	// OnPage, when set, is called after each page with all its posts consumed, with the cursor
+	// of the next page and the posts yielded from the page, and with an empty cursor once the scan is
+	// complete. It is not called when the scan is cut short by an error, the budget or the caller.
+	OnPage func(next string, yielded []*bsky.FeedDefs_PostView)
+}
+
+// FeedStats describes how a feed scan went.
+type FeedStats struct {
+	Pages int   // Number of pages fetched successfully
+	Err   error // Fetch error that ended the scan early, if any
+
+	Items    int // Number of feed items read
+	Authored int // Number of items yielded, having passed the authorship guard
+}
+
+// CollectAllTargetUserPosts fetches all posts from the target user, stopping at the first fully actioned post
+// unless opts.ScanPastActioned is set.
+// A positive opts.Budget bounds the time spent paginating; once exceeded, the posts collected so far are returned.
+func CollectAllTargetUserPosts(ctx context.Context, xrpcc *xrpc.Client, targetUserDID string, opts FeedOptions) []*bsky.FeedDefs_PostView {
+	var allTargetUserPosts []*bsky.FeedDefs_PostView
+	for post := range TargetUserPosts(ctx, xrpcc, targetUserDID, opts) {
+		allTargetUserPosts = append(allTargetUserPosts, post)
+	}
+	return allTargetUserPosts
+}
+
+// TargetUserPosts streams posts from the target user's feed newest first, fetching pages lazily as the caller consumes them.
+// opts.Source selects between the target's own posts (SourceAuthor) and the posts they liked (SourceLikes).
+// Each post is yielded at most once, even when pages overlap.
+// The sequence ends at the first fully actioned post (skipped instead with opts.ScanPastActioned), when the feed
+// is exhausted, or when collectFeed stops paginating.
+func TargetUserPosts(ctx context.Context, xrpcc *xrpc.Client, targetUserDID string, opts FeedOptions) iter.Seq[*bsky.FeedDefs_PostView] {
+	source := opts.Source
+	return func(yield func(*bsky.FeedDefs_PostView) bool) {
+		yielded := 0
+		seen := make(map[string]bool)
+		complete := func() {
+			if opts.OnPage != nil {
+				opts.OnPage("", nil)
+			}
+		}
+		fetchPage := func(cursor string) ([]*bsky.FeedDefs_FeedViewPost, *string, error) {
+			slog.Info("Fetching feed for target user", "targetUserDID", targetUserDID, "source", source, "cursor", cursor)
+			pageCtx, span := startSpan(ctx, "feed.page",
+				attribute.String("target.did", targetUserDID),
+				attribute.String("feed.source", source),
+				attribute.String("feed.cursor", cursor),
+			)
+			items, next, err := fetchFeedPage(pageCtx, xrpcc, targetUserDID, cursor, opts)
+			span.SetAttributes(attribute.Int("feed.items", len(items)))
+			if next != nil {
+				span.SetAttributes(attribute.String("feed.next_cursor", *next))
+			}
+			endSpan(span, err)
+			if err != nil {
+				slog.Error("Failed to get feed while collecting all posts",
+					append([]any{"targetUserDID", targetUserDID, "source", source}, ErrorAttrs(err)...)...,
+				)
+			} else {
+				eventFunc(opts.OnEvent).emit(EventPageFetched, "target", targetUserDID, "source", source, "cursor", cursor, "items", len(items))
+			}
+			return items, next, err
+		}
+
+		for items, next := range collectFeed(ctx, fetchPage, opts) {
+			newPosts := 0
+			var pagePosts []*bsky.FeedDefs_PostView
+			for _, item := range items {
+				post := item.Post
+				if opts.Stats != nil {
+					opts.Stats.Items++
+				}
+				if seen[post.Uri] {
+					slog.Debug("Skipping feed item, already seen on an earlier page", "postUri", post.Uri)
+					continue
+				}
+				seen[post.Uri] = true
+				newPosts++
+				slog.Info("Processing feed item", "postUri", post.Uri, "t", post.IndexedAt)
+				// Liked posts are authored by others, so the authorship guard only applies to the author feed.
+				authored := source == SourceLikes || post.Author.Did == targetUserDID
+				repostedByTarget := opts.IncludeReposts && isRepostBy(item, targetUserDID)
+				if authored || repostedByTarget {
+					if !authored {
+						slog.Info("Including post reposted by target user, the original post will be actioned",
+							"postUri", post.Uri,
+							"originalAuthorDid", post.Author.Did,
+							"targetUserDID", targetUserDID,
+						)
+					}
+					alreadyLiked := post.Viewer != nil && post.Viewer.Like != nil
+					alreadyReposted := post.Viewer != nil && post.Viewer.Repost != nil
+					if alreadyLiked && alreadyReposted {
+						if opts.ScanPastActioned {
+							slog.Debug("Skipping post already liked and reposted", "postUri", post.Uri)
+							continue
+						}
+						slog.Info("Reached a post already liked and reposted, stopping pagination", "postUri", post.Uri, "postsCollected", yielded)
+						complete()
+						return
+					}
+					yielded++
+					if opts.Stats != nil {
+						opts.Stats.Authored++
+					}
+					pagePosts = append(pagePosts, post)
+					if !yield(post) {
+						return
+					}
+				} else {
+					slog.Debug("Skipping feed item, not directly authored by target user",
+						"postUri", post.Uri,
+						"authorDid", post.Author.Did,
+						"targetUserDID", targetUserDID,
+					)
+				}
+			}
+			if newPosts == 0 && len(items) > 0 && opts.StopOnRepeatPage {
+				slog.Info("Page contained only posts already seen, stopping pagination", "postsCollected", yielded)
+				complete()
+				return
+			}
+			if next == "" {
+				complete()
+				return
+			}
+			if opts.OnPage != nil {
+				opts.OnPage(next, pagePosts)
+			}
+		}
+	}
+}
+
+// collectFeed paginates a feed through fetchPage, starting at opts.StartCursor, and yields
+// each page with the cursor of the next one, or "" for the last page. Pages may be short or
+// empty: paging goes on as long as a new cursor is returned. It stops after the last page,
+// a fetch error (recorded in opts.Stats), a cursor that does not
+// advance, opts.MaxPages pages or opts.Budget, and waits opts.PageDelay between pages.
+// Both feed sources go through it so they share these safeguards.
+func collectFeed(ctx context.Context, fetchPage func(cursor string) ([]*bsky.FeedDefs_FeedViewPost, *string, error), opts FeedOptions) iter.Seq2[[]*bsky.FeedDefs_FeedViewPost, string] {
+	return func(yield func([]*bsky.FeedDefs_FeedViewPost, string) bool) {
+		cursor := opts.StartCursor
+		start := time.Now()
+		for pages := 0; ; pages++ {
+			if opts.MaxPages > 0 && pages >= opts.MaxPages {
+				slog.Info("Maximum number of pages fetched, stopping pagination early", "maxPages", opts.MaxPages)
+				return
+			}
+			if opts.Budget > 0 && time.Since(start) > opts.Budget {
+				slog.Info("Collect budget exceeded, stopping pagination early",
+					"budget", opts.Budget,
+					"elapsed", time.Since(start),
+					"pagesFetched", pages,
+				)
+				return
+			}
+			items, nextCursor, err := fetchPage(cursor)
+			if err != nil {
+				if opts.Stats != nil {
+					opts.Stats.Err = err
+				}
+				return
+			}
+			if opts.Stats != nil {
+				opts.Stats.Pages++
+			}
+			next := ""
+			if nextCursor != nil {
+				next = *nextCursor
+			}
+			// Some servers return short or even empty pages before the end of the feed:
+			// only the cursor tells whether there is more.
+			if len(items) == 0 && next == "" {
+				slog.Info("No more posts to fetch from target user.")
+				yield(items, "")
+				return
+			}
+			if len(items) == 0 {
+				slog.Info("Empty page with a cursor, continuing pagination", "cursor", next)
+			}
+			if next != "" && next == cursor {
+				slog.Warn("Feed cursor did not advance, stopping pagination to avoid fetching the same page forever", "cursor", cursor)
+				next = ""
+			}
+			slog.Info("Cursor for next page", "cursor", next)
+			if !yield(items, next) || next == "" {
+				return
+			}
+			cursor = next
+			if opts.PageDelay > 0 {
+				if err := sleepCtx(ctx, opts.PageDelay); err != nil {
+					if opts.Stats != nil {
+						opts.Stats.Err = err
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+// TargetsPosts chains TargetUserPosts for each of targets in turn. A post found in the feeds
+// of several targets, e.g. liked by two of them with SourceLikes, is yielded once.
+func TargetsPosts(ctx context.Context, xrpcc *xrpc.Client, targets []string, opts FeedOptions) iter.Seq[*bsky.FeedDefs_PostView] {
+	return func(yield func(*bsky.FeedDefs_PostView) bool) {
+		seen := make(map[string]bool)
+		for _, target := range targets {
+			for post := range TargetUserPosts(ctx, xrpcc, target, opts) {
+				if seen[post.Uri] {
+					slog.Debug("Skipping post, already collected from another target", "postUri", post.Uri, "targetUserDID", target)
+					continue
+				}
+				seen[post.Uri] = true
+				if !yield(post) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// isRepostBy reports whether item appears in the feed because did reposted it.
+func isRepostBy(item *bsky.FeedDefs_FeedViewPost, did string) bool {
+	return item.Reason != nil && item.Reason.FeedDefs_ReasonRepost != nil &&
+		item.Reason.FeedDefs_ReasonRepost.By != nil && item.Reason.FeedDefs_ReasonRepost.By.Did == did
+}
+
+// NewestPostBoundary returns a boundary at the newest post in the target's feed,
+// or at the current time when the feed has no posts.
+func NewestPostBoundary(ctx context.Context, xrpcc *xrpc.Client, targetUserDID string, opts FeedOptions) (*Boundary, error) {
+	items, _, err := fetchFeedPage(ctx, xrpcc, targetUserDID, "", opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		if opts.Source == SourceLikes || item.Post.Author.Did == targetUserDID {
+			return &Boundary{URI: item.Post.Uri, IndexedAt: item.Post.IndexedAt}, nil
+		}
+	}
+	return &Boundary{IndexedAt: FormatTimestamp(time.Now())}, nil
+}
+
+// fetchFeedPage fetches one page of the target's feed from the configured source.
+// When opts.ReadClient is set the page is read through it and viewer state is hydrated through xrpcc.
+func fetchFeedPage(ctx context.Context, xrpcc *xrpc.Client, targetUserDID, cursor string, opts FeedOptions) ([]*bsky.FeedDefs_FeedViewPost, *string, error) {
+	ctx, cancel := withTimeout(ctx, opts.ReadTimeout)
+	defer cancel()
+	reader := xrpcc
+	if opts.ReadClient != nil {
+		reader = opts.ReadClient
+	}
+
+	var items []*bsky.FeedDefs_FeedViewPost
+	var next *string
+	if opts.Source == SourceLikes {
+		likes, err := bsky.FeedGetActorLikes(ctx, reader, targetUserDID, cursor, 10)
+		if err != nil {
+			return nil, nil, err
+		}
+		items, next = likes.Feed, likes.Cursor
+	} else {
+		// With AuthorFilterAuthorThreads, items can carry the thread context of self-replies in item.Reply;
+		// item.Post is still the post by the target, so the authorship check is unaffected.
+		feed, err := bsky.FeedGetAuthorFeed(ctx, reader, targetUserDID, cursor, opts.Filter, false, 10)
+		if err != nil {
+			return nil, nil, err
+		}
+		items, next = feed.Feed, feed.Cursor
+	}
+
+	if opts.ReadClient != nil {
+		if err := hydrateViewerState(ctx, xrpcc, items); err != nil {
+			return nil, nil, fmt.Errorf("failed to hydrate viewer state: %w", err)
+		}
+	}
+	return items, next, nil
+}
+
+// FetchPostsByURI fetches the given posts, in the requested order, with the authenticated client's viewer state.
+// URIs that cannot be found (e.g. deleted posts) are logged and left out.
+func FetchPostsByURI(ctx context.Context, xrpcc *xrpc.Client, uris []string) ([]*bsky.FeedDefs_PostView, error) {
+	byURI := make(map[string]*bsky.FeedDefs_PostView, len(uris))
+	for chunk := range slices.Chunk(uris, maxURIsPerRequest) {
+		out, err := bsky.FeedGetPosts(ctx, xrpcc, chunk)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch posts by URI: %w", err)
+		}
+		for _, post := range out.Posts {
+			byURI[post.Uri] = post
+		}
+	}
+	posts := make([]*bsky.FeedDefs_PostView, 0, len(uris))
+	for _, uri := range uris {
+		post, ok := byURI[uri]
+		if !ok {
+			slog.Warn("Post not found, skipping", "postUri", uri)
+			continue
+		}
+		posts = append(posts, post)
+	}
+	return posts, nil
+}
+
+// maxURIsPerRequest is the maximum number of URIs accepted by app.bsky.feed.getPosts.
+const maxURIsPerRequest = 25
+
+// hydrateViewerState replaces the viewer state of each item with the one seen by the authenticated client.
+func hydrateViewerState(ctx context.Context, xrpcc *xrpc.Client, items []*bsky.FeedDefs_FeedViewPost) error {
+	for chunk := range slices.Chunk(items, maxURIsPerRequest) {
+		uris := make([]string, 0, len(chunk))
+		for _, item := range chunk {
+			uris = append(uris, item.Post.Uri)
+		}
+		out, err := bsky.FeedGetPosts(ctx, xrpcc, uris)
+		if err != nil {
+			return err
+		}
+		viewers := make(map[string]*bsky.FeedDefs_ViewerState, len(out.Posts))
+		for _, post := range out.Posts {
+			viewers[post.Uri] = post.Viewer
+		}
+		for _, item := range chunk {
+			item.Post.Viewer = viewers[item.Post.Uri]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package reposter
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+)
+
+// scriptedFeed is a fetchPage of collectFeed serving pages by cursor and recording the cursors fetched.
+type scriptedFeed struct {
+	pages   map[string]fakePage
+	fetched []string
+}
+
+func (s *scriptedFeed) fetchPage(cursor string) ([]*bsky.FeedDefs_FeedViewPost, *string, error) {
+	s.fetched = append(s.fetched, cursor)
+	page := s.pages[cursor]
+	items := make([]*bsky.FeedDefs_FeedViewPost, 0, len(page.Posts))
+	for _, post := range page.Posts {
+		items = append(items, &bsky.FeedDefs_FeedViewPost{Post: post})
+	}
+	if page.Next == "" {
+		return items, nil, nil
+	}
+	return items, &page.Next, nil
+}
+
+func newScriptedFeed(pages ...fakePage) *scriptedFeed {
+	s := &scriptedFeed{pages: make(map[string]fakePage)}
+	for _, page := range pages {
+		s.pages[page.Cursor] = page
+	}
+	return s
+}
+
+func TestCollectFeedStopsOnRepeatedCursor(t *testing.T) {
+	feed := newScriptedFeed(
+		fakePage{Next: "a", Posts: []*bsky.FeedDefs_PostView{testPost(testTarget, 4)}},
+		fakePage{Cursor: "a", Next: "a", Posts: []*bsky.FeedDefs_PostView{testPost(testTarget, 3)}},
+	)
+	var stats FeedStats
+	var nexts []string
+	for _, next := range collectFeed(context.Background(), feed.fetchPage, FeedOptions{Stats: &stats}) {
+		nexts = append(nexts, next)
+	}
+	if !slices.Equal(feed.fetched, []string{"", "a"}) {
+		t.Errorf("collectFeed fetched the cursors %q, want each once", feed.fetched)
+	}
+	// The page returning its own cursor is still yielded, as the last one.
+	if !slices.Equal(nexts, []string{"a", ""}) {
+		t.Errorf("collectFeed yielded the next cursors %q, want %q", nexts, []string{"a", ""})
+	}
+	if stats.Pages != 2 || stats.Err != nil {
+		t.Errorf("collectFeed recorded %d pages and error %v, want 2 pages and no error", stats.Pages, stats.Err)
+	}
+}
+
+func TestCollectFeedStopsAtMaxPages(t *testing.T) {
+	feed := newScriptedFeed(
+		fakePage{Next: "a", Posts: []*bsky.FeedDefs_PostView{testPost(testTarget, 3)}},
+		fakePage{Cursor: "a", Next: "b", Posts: []*bsky.FeedDefs_PostView{testPost(testTarget, 2)}},
+		fakePage{Cursor: "b", Posts: []*bsky.FeedDefs_PostView{testPost(testTarget, 1)}},
+	)
+	for range collectFeed(context.Background(), feed.fetchPage, FeedOptions{MaxPages: 2}) {
+	}
+	if !slices.Equal(feed.fetched, []string{"", "a"}) {
+		t.Errorf("collectFeed fetched the cursors %q, want the first two pages only", feed.fetched)
+	}
+}
+
+func TestCollectFeedContinuesPastShortAndEmptyPages(t *testing.T) {
+	feed := newScriptedFeed(
+		fakePage{Next: "a", Posts: []*bsky.FeedDefs_PostView{testPost(testTarget, 3)}},
+		fakePage{Cursor: "a", Next: "b"},
+		fakePage{Cursor: "b", Posts: []*bsky.FeedDefs_PostView{testPost(testTarget, 2), testPost(testTarget, 1)}},
+	)
+	var uris []string
+	for items := range collectFeed(context.Background(), feed.fetchPage, FeedOptions{}) {
+		for _, item := range items {
+			uris = append(uris, item.Post.Uri)
+		}
+	}
+	if !slices.Equal(feed.fetched, []string{"", "a", "b"}) {
+		t.Errorf("collectFeed fetched the cursors %q, want every page", feed.fetched)
+	}
+	want := []string{testPost(testTarget, 3).Uri, testPost(testTarget, 2).Uri, testPost(testTarget, 1).Uri}
+	if !slices.Equal(uris, want) {
+		t.Errorf("collectFeed yielded %v, want %v", uris, want)
+	}
+}
+
+func TestCollectFeedEndsOnEmptyPageWithoutCursor(t *testing.T) {
+	feed := newScriptedFeed(
+		fakePage{Next: "a", Posts: []*bsky.FeedDefs_PostView{testPost(testTarget, 1)}},
+		fakePage{Cursor: "a"},
+	)
+	var nexts []string
+	for _, next := range collectFeed(context.Background(), feed.fetchPage, FeedOptions{}) {
+		nexts = append(nexts, next)
+	}
+	if !slices.Equal(feed.fetched, []string{"", "a"}) || !slices.Equal(nexts, []string{"a", ""}) {
+		t.Errorf("collectFeed fetched %q and yielded the next cursors %q, want to end on the empty page", feed.fetched, nexts)
+	}
+}
+
+func TestTargetsPostsYieldsSharedPostOnce(t *testing.T) {
+	pds := newFakePDS(t)
+	const other = "did:plc:other"
+	shared := testPost("did:plc:author", 1)
+	pds.likedPosts(testTarget, chain([]*bsky.FeedDefs_PostView{shared, testPost("did:plc:author", 2)})...)
+	pds.likedPosts(other, chain([]*bsky.FeedDefs_PostView{shared, testPost("did:plc:author", 3)})...)
+
+	var uris []string
+	for post := range TargetsPosts(context.Background(), pds.client(), []string{testTarget, other}, FeedOptions{Source: SourceLikes}) {
+		uris = append(uris, post.Uri)
+	}
+	want := []string{shared.Uri, testPost("did:plc:author", 2).Uri, testPost("did:plc:author", 3).Uri}
+	if !slices.Equal(uris, want) {
+		t.Errorf("TargetsPosts yielded %v, want %v", uris, want)
+	}
+}
+
+func TestTargetUserPostsSkipsPostsOfOverlappingPages(t *testing.T) {
+	pds := newFakePDS(t)
+	// New posts shifted the feed while it was paginated, so post 3 comes back on the second page.
+	pds.feed(testTarget, chain(
+		[]*bsky.FeedDefs_PostView{testPost(testTarget, 4), testPost(testTarget, 3)},
+		[]*bsky.FeedDefs_PostView{testPost(testTarget, 3), testPost(testTarget, 2)},
+		[]*bsky.FeedDefs_PostView{testPost(testTarget, 1)},
+	)...)
+
+	var stats FeedStats
+	var uris []string
+	for post := range TargetUserPosts(context.Background(), pds.client(), testTarget, FeedOptions{Stats: &stats}) {
+		uris = append(uris, post.Uri)
+	}
+	want := []string{testPost(testTarget, 4).Uri, testPost(testTarget, 3).Uri, testPost(testTarget, 2).Uri, testPost(testTarget, 1).Uri}
+	if !slices.Equal(uris, want) {
+		t.Errorf("TargetUserPosts yielded %v, want %v", uris, want)
+	}
+	if stats.Items != 5 || stats.Authored != 4 {
+		t.Errorf("TargetUserPosts read %d items and yielded %d, want 5 and 4", stats.Items, stats.Authored)
+	}
+}
+
+func TestTargetUserPostsStopsOnRepeatedPage(t *testing.T) {
+	pds := newFakePDS(t)
+	pds.feed(testTarget, chain(
+		[]*bsky.FeedDefs_PostView{testPost(testTarget, 4), testPost(testTarget, 3)},
+		[]*bsky.FeedDefs_PostView{testPost(testTarget, 4), testPost(testTarget, 3)},
+		[]*bsky.FeedDefs_PostView{testPost(testTarget, 2)},
+	)...)
+
+	var uris []string
+	for post := range TargetUserPosts(context.Background(), pds.client(), testTarget, FeedOptions{StopOnRepeatPage: true}) {
+		uris = append(uris, post.Uri)
+	}
+	want := []string{testPost(testTarget, 4).Uri, testPost(testTarget, 3).Uri}
+	if !slices.Equal(uris, want) {
+		t.Errorf("TargetUserPosts yielded %v, want %v", uris, want)
+	}
+	if got := pds.fetchedCursors(); !slices.Equal(got, []string{"", "c1"}) {
+		t.Errorf("TargetUserPosts fetched the cursors %q, want to stop after the repeated page", got)
+	}
+}
+
+// selfReply returns the feed item of post n of testTarget replying to parent in the thread of root,
+// with the thread context of the posts_and_author_threads filter. A nil root stands for a deleted
+// one, which a nil parent is too.
+func selfReply(n int, root, parent *bsky.FeedDefs_PostView) *bsky.FeedDefs_FeedViewPost {
+	post := testPost(testTarget, n)
+	deleted := &bsky.FeedDefs_NotFoundPost{Uri: fmt.Sprintf("at://%s/app.bsky.feed.post/deleted", testTarget), NotFound: true}
+	rootRef := &atproto.RepoStrongRef{Uri: deleted.Uri, Cid: "bafydeleted"}
+	reply := &bsky.FeedDefs_ReplyRef{
+		Root:   &bsky.FeedDefs_ReplyRef_Root{FeedDefs_NotFoundPost: deleted},
+		Parent: &bsky.FeedDefs_ReplyRef_Parent{FeedDefs_NotFoundPost: deleted},
+	}
+	if root != nil {
+		rootRef = &atproto.RepoStrongRef{Uri: root.Uri, Cid: root.Cid}
+		reply.Root = &bsky.FeedDefs_ReplyRef_Root{FeedDefs_PostView: root}
+	}
+	parentRef := rootRef
+	if parent != nil {
+		parentRef = &atproto.RepoStrongRef{Uri: parent.Uri, Cid: parent.Cid}
+		reply.Parent = &bsky.FeedDefs_ReplyRef_Parent{FeedDefs_PostView: parent}
+	}
+	postRecord(post).Reply = &bsky.FeedPost_ReplyRef{Root: rootRef, Parent: parentRef}
+	return &bsky.FeedDefs_FeedViewPost{Post: post, Reply: reply}
+}
+
+// selfThreads serves, under AuthorFilterAuthorThreads, the thread of post 1 continued by posts 2
+// and 3, the top-level post 4 and post 5 replying to a deleted post of the target.
+func selfThreads(pds *fakePDS) {
+	root := testPost(testTarget, 1)
+	second := selfReply(2, root, root)
+	pds.feed(testTarget, fakePage{Items: []*bsky.FeedDefs_FeedViewPost{
+		selfReply(5, nil, nil),
+		{Post: testPost(testTarget, 4)},
+		selfReply(3, root, second.Post),
+		second,
+		{Post: root},
+	}})
+}
+
+func TestTargetUserPostsReadsSelfThreads(t *testing.T) {
+	pds := newFakePDS(t)
+	selfThreads(pds)
+
+	var got []string
+	for post := range TargetUserPosts(context.Background(), pds.client(), testTarget, FeedOptions{Filter: AuthorFilterAuthorThreads}) {
+		got = append(got, post.Uri)
+	}
+	var want []string
+	for _, n := range []int{5, 4, 3, 2, 1} {
+		want = append(want, testPost(testTarget, n).Uri)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("TargetUserPosts yielded %v, want %v", got, want)
+	}
+	if filters := pds.feedParams("filter"); !slices.Equal(filters, []string{AuthorFilterAuthorThreads}) {
+		t.Errorf("TargetUserPosts requested the filters %q, want %s", filters, AuthorFilterAuthorThreads)
+	}
+}
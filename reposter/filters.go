@@ -0,0 +1,329 @@
+package reposter
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/rivo/uniseg"
+)
+
+// IsEligible reports whether post still needs a like or repost and passes filters.
+func IsEligible(post *bsky.FeedDefs_PostView, filters Filters) bool {
+	return needsAction(post) && filters.Allows(post)
+}
+
+// needsAction reports whether the authenticated account has not both liked and reposted post yet.
+func needsAction(post *bsky.FeedDefs_PostView) bool {
+	alreadyLiked := post.Viewer != nil && post.Viewer.Like != nil
+	alreadyReposted := post.Viewer != nil && post.Viewer.Repost != nil
+	return !alreadyLiked || !alreadyReposted
+}
+
+// eligiblePosts yields the eligible posts of seq, incrementing *skipped for every other post
+// and, when funnel is not nil, counting every post in its filter stages.
+// When explain is set, one line per post lists every check it passed or failed and the decision.
+func eligiblePosts(seq iter.Seq[*bsky.FeedDefs_PostView], filters Filters, skipped *int, funnel *Funnel, explain bool) iter.Seq[*bsky.FeedDefs_PostView] {
+	return func(yield func(*bsky.FeedDefs_PostView) bool) {
+		for post := range seq {
+			if funnel != nil {
+				funnel.record(post, filters)
+			}
+			var eligible bool
+			if explain {
+				eligible = filters.Explain(post)
+			} else {
+				eligible = IsEligible(post, filters)
+			}
+			if !eligible {
+				*skipped++
+				continue
+			}
+			if !yield(post) {
+				return
+			}
+		}
+	}
+}
+
+// markSeen yields the posts of seq unchanged, adding each URI to seen.
+func markSeen(seq iter.Seq[*bsky.FeedDefs_PostView], seen map[string]bool) iter.Seq[*bsky.FeedDefs_PostView] {
+	return func(yield func(*bsky.FeedDefs_PostView) bool) {
+		for post := range seq {
+			seen[post.Uri] = true
+			if !yield(post) {
+				return
+			}
+		}
+	}
+}
+
+// uriSet returns the set of uris, or nil when there are none so that every post is allowed.
+func uriSet(uris []string) map[string]bool {
+	if len(uris) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(uris))
+	for _, uri := range uris {
+		set[uri] = true
+	}
+	return set
+}
+
+// warnUnseen logs every allowlisted URI of only missing from seen, in order.
+func warnUnseen(only, seen map[string]bool) {
+	for _, uri := range slices.Sorted(maps.Keys(only)) {
+		if !seen[uri] {
+			slog.Warn("Allowlisted post not found among the posts collected this run", "postUri", uri)
+		}
+	}
+}
+
+// Filters holds the user-configurable eligibility criteria. A post must satisfy all of them.
+type Filters struct {
+	MinLikes   int64
+	MinReposts int64
+	NewerThan  time.Time // When set, only posts indexed after this instant are eligible
+	MediaOnly  bool      // Only posts embedding images or video are eligible
+
+	// MinTextLength, when positive, excludes posts whose text, trimmed of surrounding spaces,
+	// is shorter than this many characters, counted as graphemes so an emoji counts as one.
+	// With AllowEmptyText posts without any text, such as bare images, are still eligible.
+	MinTextLength  int
+	AllowEmptyText bool
+
+	// MuteWords excludes posts whose text contains any of these words, case-insensitively.
+	// With MuteWholeWord a word only matches when not surrounded by letters or digits.
+	MuteWords     []string
+	MuteWholeWord bool
+
+	// MaxPostAge, when positive, is a safety rail dropping every post created or indexed
+	// longer ago than this, whatever the other selection options.
+	MaxPostAge time.Duration
+
+	// EngagedPosts maps the URIs of posts already engaged with otherwise (e.g. "replied" or
+	// "quoted") to that engagement; they are never eligible.
+	EngagedPosts map[string]string
+
+	// ExcludedAuthors maps author DIDs whose posts are never eligible to the reason logged when skipping them.
+	ExcludedAuthors map[string]string
+
+	// OnlyURIs, when set, holds the URIs of the only posts that may be eligible.
+	OnlyURIs map[string]bool
+}
+
+// predicate is a named eligibility check. check reports whether post passes and,
+// when it does not, the message and attributes logged at level when skipping it.
+type predicate struct {
+	name  string
+	level slog.Level
+	check func(f Filters, post *bsky.FeedDefs_PostView) (ok bool, msg string, attrs []any)
+}
+
+// predicates lists the checks applied by Filters.Allows, in evaluation order.
+var predicates = []predicate{
+	// Likes and reposts reference the post by URI and CID; a tombstoned item may lack either.
+	{"has-strong-ref", slog.LevelWarn, func(f Filters, post *bsky.FeedDefs_PostView) (bool, string, []any) {
+		return post.Uri != "" && post.Cid != "", "Skipping post without a URI or CID", []any{"cid", post.Cid}
+	}},
+	{"only-uri", slog.LevelDebug, func(f Filters, post *bsky.FeedDefs_PostView) (bool, string, []any) {
+		return f.OnlyURIs == nil || f.OnlyURIs[post.Uri], "Skipping post not in the allowlist", nil
+	}},
+	{"excluded-author", slog.LevelInfo, func(f Filters, post *bsky.FeedDefs_PostView) (bool, string, []any) {
+		reason, excluded := f.ExcludedAuthors[post.Author.Did]
+		return !excluded, "Skipping post by excluded author", []any{"authorDid", post.Author.Did, "reason", reason}
+	}},
+	{"prior-engagement", slog.LevelInfo, func(f Filters, post *bsky.FeedDefs_PostView) (bool, string, []any) {
+		engagement, engaged := f.EngagedPosts[post.Uri]
+		return !engaged, "Skipping post you already engaged with", []any{"engagement", engagement}
+	}},
+	{"engagement", slog.LevelInfo, func(f Filters, post *bsky.FeedDefs_PostView) (bool, string, []any) {
+		likes := countOrZero(post.LikeCount)
+		reposts := countOrZero(post.RepostCount)
+		return likes >= f.MinLikes && reposts >= f.MinReposts, "Skipping post below engagement threshold", []any{
+			"likeCount", likes,
+			"repostCount", reposts,
+			"minLikes", f.MinLikes,
+			"minReposts", f.MinReposts,
+		}
+	}},
+	{"media-only", slog.LevelDebug, func(f Filters, post *bsky.FeedDefs_PostView) (bool, string, []any) {
+		return !f.MediaOnly || hasMedia(post), "Skipping post without images or video", nil
+	}},
+	{"min-text-length", slog.LevelDebug, func(f Filters, post *bsky.FeedDefs_PostView) (bool, string, []any) {
+		if f.MinTextLength <= 0 {
+			return true, "", nil
+		}
+		var text string
+		if record := postRecord(post); record != nil {
+			text = strings.TrimSpace(record.Text)
+		}
+		if text == "" && f.AllowEmptyText {
+			return true, "", nil
+		}
+		length := uniseg.GraphemeClusterCount(text)
+		return length >= f.MinTextLength, "Skipping post with text shorter than the minimum", []any{
+			"textLength", length,
+			"minTextLength", f.MinTextLength,
+		}
+	}},
+	{"mute-words", slog.LevelDebug, func(f Filters, post *bsky.FeedDefs_PostView) (bool, string, []any) {
+		if len(f.MuteWords) == 0 {
+			return true, "", nil
+		}
+		record := postRecord(post)
+		if record == nil {
+			return true, "", nil
+		}
+		word := mutedWord(record.Text, f.MuteWords, f.MuteWholeWord)
+		return word == "", "Skipping post containing a muted word", []any{"mutedWord", word}
+	}},
+	{"newer-than-boundary", slog.LevelDebug, func(f Filters, post *bsky.FeedDefs_PostView) (bool, string, []any) {
+		if f.NewerThan.IsZero() {
+			return true, "", nil
+		}
+		indexedAt, err := ParseTimestamp(post.IndexedAt)
+		return err == nil && indexedAt.After(f.NewerThan), "Skipping post not newer than boundary", []any{
+			"indexedAt", post.IndexedAt,
+			"boundary", f.NewerThan,
+		}
+	}},
+	// Keep last: it is a safety rail, applied after every selection filter.
+	{"max-post-age", slog.LevelWarn, func(f Filters, post *bsky.FeedDefs_PostView) (bool, string, []any) {
+		if f.MaxPostAge <= 0 {
+			return true, "", nil
+		}
+		// Use the older of both timestamps, so neither a backdated record nor a late index lets an old post through.
+		created, indexed := postTime(post, SortCreatedAt), postTime(post, SortIndexedAt)
+		oldest := created
+		if indexed.Before(created) {
+			oldest = indexed
+		}
+		return !oldest.IsZero() && time.Since(oldest) <= f.MaxPostAge, "MAX POST AGE: dropping post older than the hard cutoff", []any{
+			"createdAt", created,
+			"indexedAt", post.IndexedAt,
+			"maxPostAge", f.MaxPostAge,
+		}
+	}},
+}
+
+// Allows reports whether post satisfies every configured filter, logging the first one it fails.
+func (f Filters) Allows(post *bsky.FeedDefs_PostView) bool {
+	for _, p := range predicates {
+		if ok, msg, attrs := p.check(f, post); !ok {
+			slog.Log(context.Background(), p.level, msg, append([]any{"postUri", post.Uri}, attrs...)...)
+			return false
+		}
+	}
+	return true
+}
+
+// Explain evaluates every check against post, including whether it still needs an action,
+// logs a single line listing the checks it passed and failed, and reports whether it is eligible.
+func (f Filters) Explain(post *bsky.FeedDefs_PostView) bool {
+	var passed, failed []string
+	if needsAction(post) {
+		passed = append(passed, "needs-action")
+	} else {
+		failed = append(failed, "needs-action")
+	}
+	for _, p := range predicates {
+		if ok, _, _ := p.check(f, post); ok {
+			passed = append(passed, p.name)
+		} else {
+			failed = append(failed, p.name)
+		}
+	}
+	decision := "eligible"
+	if len(failed) > 0 {
+		decision = "skipped"
+	}
+	slog.Info("Explain post selection",
+		"postUri", post.Uri,
+		"passed", passed,
+		"failed", failed,
+		"decision", decision,
+	)
+	return len(failed) == 0
+}
+
+// mutedWord returns the first of words found in text, ignoring case, or "" if none is.
+// With wholeWord, an occurrence only counts when it is not adjacent to a letter or digit.
+func mutedWord(text string, words []string, wholeWord bool) string {
+	text = strings.ToLower(text)
+	for _, word := range words {
+		needle := strings.ToLower(word)
+		if needle == "" {
+			continue
+		}
+		for from := 0; ; {
+			i := strings.Index(text[from:], needle)
+			if i < 0 {
+				break
+			}
+			start, end := from+i, from+i+len(needle)
+			if !wholeWord || (!isWordRune(lastRune(text[:start])) && !isWordRune(firstRune(text[end:]))) {
+				return word
+			}
+			from = start + 1
+		}
+	}
+	return ""
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func firstRune(s string) rune {
+	r, _ := utf8.DecodeRuneInString(s)
+	return r
+}
+
+func lastRune(s string) rune {
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return r
+}
+
+// ReadWordList reads one word or phrase per line from path, ignoring blank lines and lines starting with #.
+func ReadWordList(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read word list %s: %w", path, err)
+	}
+	var words []string
+	for line := range strings.Lines(string(data)) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	return words, nil
+}
+
+// postRecord returns the decoded app.bsky.feed.post record of the post, or nil if unavailable.
+func postRecord(post *bsky.FeedDefs_PostView) *bsky.FeedPost {
+	if post.Record == nil {
+		return nil
+	}
+	record, _ := post.Record.Val.(*bsky.FeedPost)
+	return record
+}
+
+// countOrZero dereferences an optional counter, treating nil as zero.
+func countOrZero(n *int64) int64 {
+	if n == nil {
+		return 0
+	}
+	return *n
+}
@@ -0,0 +1,53 @@
+package reposter
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+)
+
+func TestIsEligibleRequiresStrongRef(t *testing.T) {
+	noCID := testPost(testTarget, 1)
+	noCID.Cid = ""
+	noURI := testPost(testTarget, 2)
+	noURI.Uri = ""
+	tests := []struct {
+		name string
+		post *bsky.FeedDefs_PostView
+		want bool
+	}{
+		{"uri and cid", testPost(testTarget, 3), true},
+		{"no cid", noCID, false},
+		{"no uri", noURI, false},
+	}
+	for _, tt := range tests {
+		if got := IsEligible(tt.post, Filters{}); got != tt.want {
+			t.Errorf("IsEligible(post with %s) = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRunSkipsPostWithoutCID(t *testing.T) {
+	pds := newFakePDS(t)
+	noCID := testPost(testTarget, 1)
+	noCID.Cid = ""
+	pds.feed(testTarget, chain([]*bsky.FeedDefs_PostView{testPost(testTarget, 2), noCID})...)
+
+	result, err := Run(context.Background(), testConfig(pds))
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	want := []string{testPost(testTarget, 2).Uri}
+	if !slices.Equal(result.ActionedURIs, want) {
+		t.Errorf("Run actioned %v, want %v", result.ActionedURIs, want)
+	}
+	likes, reposts := actionedSubjects(pds.records())
+	if slices.Contains(likes, noCID.Uri) || slices.Contains(reposts, noCID.Uri) {
+		t.Errorf("Run wrote a record for the post without a CID")
+	}
+	if result.Skipped != 1 {
+		t.Errorf("Run skipped %d posts, want 1", result.Skipped)
+	}
+}
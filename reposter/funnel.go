@@ -0,0 +1,59 @@
+package reposter
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+)
+
+// Funnel counts the posts left at each stage of a run's selection, to diagnose why fewer
+// posts than expected, or none, were actioned.
+type Funnel struct {
+	Collected     int            // Feed items read, search results or posts requested by URI
+	PassedAuthor  int            // Posts kept by the authorship guard of target feeds
+	Failed        map[string]int // Posts failing the filters, by name of the first check failed
+	PassedFilters int            // Posts passing every filter
+	NotActioned   int            // Posts passing the filters that still need a like or repost
+	Selected      int            // Posts handed to the actions, or planned
+}
+
+// record counts post, kept by the authorship guard, in the filter stages of the funnel.
+// The checks are evaluated without logging, in the order of Filters.Allows.
+func (f *Funnel) record(post *bsky.FeedDefs_PostView, filters Filters) {
+	for _, p := range predicates {
+		if ok, _, _ := p.check(filters, post); !ok {
+			if f.Failed == nil {
+				f.Failed = make(map[string]int)
+			}
+			f.Failed[p.name]++
+			return
+		}
+	}
+	f.PassedFilters++
+	if needsAction(post) {
+		f.NotActioned++
+	}
+}
+
+// String renders the funnel as one line per stage, with the filters failed next to the filter stage.
+func (f Funnel) String() string {
+	var failed []string
+	for _, name := range slices.Sorted(maps.Keys(f.Failed)) {
+		failed = append(failed, fmt.Sprintf("%s %d", name, f.Failed[name]))
+	}
+	var b strings.Builder
+	b.WriteString("Selection funnel:\n")
+	fmt.Fprintf(&b, "  %-22s %5d\n", "collected", f.Collected)
+	fmt.Fprintf(&b, "  %-22s %5d\n", "passed-author", f.PassedAuthor)
+	fmt.Fprintf(&b, "  %-22s %5d", "passed-filters", f.PassedFilters)
+	if len(failed) > 0 {
+		fmt.Fprintf(&b, "   (failed: %s)", strings.Join(failed, ", "))
+	}
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "  %-22s %5d\n", "not-already-actioned", f.NotActioned)
+	fmt.Fprintf(&b, "  %-22s %5d\n", "selected", f.Selected)
+	return b.String()
+}
@@ -0,0 +1,42 @@
+package reposter
+
+import (
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// MaxCreatedAtJitter is the largest jitter accepted for the createdAt of records: beyond it
+// the timestamps would misrepresent when the actions happened.
+const MaxCreatedAtJitter = time.Minute
+
+// Jitter backdates the createdAt of records by a random amount up to Max, so consecutive
+// records do not carry perfectly aligned timestamps. A nil *Jitter applies no offset.
+// It is safe for concurrent use.
+type Jitter struct {
+	Max time.Duration
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// NewJitter returns a jitter of up to max drawn from a generator seeded with seed, or nil when
+// max is not positive. The same seed yields the same sequence of offsets.
+func NewJitter(max time.Duration, seed uint64) *Jitter {
+	if max <= 0 {
+		return nil
+	}
+	return &Jitter{Max: max, rng: rand.New(rand.NewPCG(seed, seed))}
+}
+
+// CreatedAt returns the timestamp to write in a record created at now. Offsets are only
+// drawn backwards, so a record is never dated in the future.
+func (j *Jitter) CreatedAt(now time.Time) time.Time {
+	if j == nil {
+		return now
+	}
+	j.mu.Lock()
+	offset := time.Duration(j.rng.Int64N(int64(j.Max) + 1))
+	j.mu.Unlock()
+	return now.Add(-offset)
+}
@@ -0,0 +1,121 @@
+package reposter
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// labelChecker queries a labeler for the labels it applied to posts and their authors,
+// to skip posts carrying a blocked label.
+type labelChecker struct {
+	client      *xrpc.Client
+	did         string
+	block       []string
+	readTimeout time.Duration
+
+	// active holds the label values currently applied by the labeler to each subject already
+	// queried in this run: a post URI or an author DID.
+	active map[string][]string
+}
+
+// newLabelChecker resolves the labeler service of did, reached with clientOpts.
+// A positive readTimeout bounds each query.
+func newLabelChecker(ctx context.Context, clientOpts ClientOptions, did string, block []string, readTimeout time.Duration) (*labelChecker, error) {
+	// Same network settings as the PDS client, but without DPoP proofs.
+	resolveOpts := ClientOptions{TLSConfig: clientOpts.TLSConfig, Proxy: clientOpts.Proxy}
+	endpoint, err := resolveLabelerForDID(ctx, NewXRPCClient(resolveOpts).Client, did)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve labeler: %w", err)
+	}
+	resolveOpts.Host = endpoint
+	resolveOpts.ConnectTimeout = clientOpts.ConnectTimeout
+	return &labelChecker{
+		client:      NewXRPCClient(resolveOpts),
+		did:         did,
+		block:       block,
+		readTimeout: readTimeout,
+		active:      make(map[string][]string),
+	}, nil
+}
+
+// blockedLabel returns the first blocked label applied to post or to its author, and the
+// subject carrying it, or an empty label when there is none.
+func (c *labelChecker) blockedLabel(ctx context.Context, post *bsky.FeedDefs_PostView) (label, subject string, err error) {
+	subjects := []string{post.Uri, post.Author.Did}
+	var missing []string
+	for _, s := range subjects {
+		if _, ok := c.active[s]; !ok {
+			missing = append(missing, s)
+		}
+	}
+	if len(missing) > 0 {
+		if err := c.query(ctx, missing); err != nil {
+			return "", "", err
+		}
+	}
+	for _, s := range subjects {
+		for _, val := range c.active[s] {
+			if slices.Contains(c.block, val) {
+				return val, s, nil
+			}
+		}
+	}
+	return "", "", nil
+}
+
+// query fetches the labels applied to subjects and caches those still in effect: the latest
+// label for each value, unless it is a negation or has expired.
+func (c *labelChecker) query(ctx context.Context, subjects []string) error {
+	type key struct{ subject, val string }
+	latest := make(map[key]*atproto.LabelDefs_Label)
+	var cursor string
+	for {
+		queryCtx, cancel := withTimeout(ctx, c.readTimeout)
+		out, err := atproto.LabelQueryLabels(queryCtx, c.client, cursor, 250, []string{c.did}, subjects)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("failed to query labeler %s: %w", c.did, err)
+		}
+		for _, l := range out.Labels {
+			if l.Src != c.did {
+				continue
+			}
+			k := key{l.Uri, l.Val}
+			// Timestamps written by one labeler share a format, so they compare as strings.
+			if prev, ok := latest[k]; !ok || prev.Cts < l.Cts {
+				latest[k] = l
+			}
+		}
+		if out.Cursor == nil || *out.Cursor == "" || len(out.Labels) == 0 {
+			break
+		}
+		cursor = *out.Cursor
+	}
+
+	for _, s := range subjects {
+		c.active[s] = []string{} // Cached even when empty, so the subject is not queried again
+	}
+	now := time.Now()
+	for k, l := range latest {
+		if l.Neg != nil && *l.Neg {
+			continue
+		}
+		if l.Exp != nil {
+			if exp, err := ParseTimestamp(*l.Exp); err == nil && !exp.After(now) {
+				continue
+			}
+		}
+		if _, ok := c.active[k.subject]; ok {
+			c.active[k.subject] = append(c.active[k.subject], k.val)
+		}
+	}
+	slog.Debug("Queried labeler", "labeler", c.did, "subjects", subjects, "labels", len(latest))
+	return nil
+}
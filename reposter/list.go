@@ -0,0 +1,160 @@
+package reposter
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// ActionedRecord is a like or repost record found in the authenticated account's repo.
+type ActionedRecord struct {
+	Type       string // "like" or "repost"
+	RecordURI  string // URI of the like or repost record
+	SubjectURI string // URI of the post liked or reposted
+	CreatedAt  string
+}
+
+// ListActioned authenticates with cfg and returns the like and repost records of the
+// account whose subject is a post authored by cfg.TargetDID, newest first within each type.
+func ListActioned(ctx context.Context, cfg Config) ([]ActionedRecord, error) {
+	cfg = cfg.withDefaults()
+	if cfg.TargetDID == "" {
+		return nil, fmt.Errorf("target DID is required")
+	}
+	clientOpts, err := clientOptions(cfg, nil)
+	if err != nil {
+		return nil, err
+	}
+	xrpcc, did, _, err := connect(ctx, cfg, clientOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []ActionedRecord
+	for _, kind := range []struct{ actionType, collection string }{
+		{"like", "app.bsky.feed.like"},
+		{"repost", "app.bsky.feed.repost"},
+	} {
+		found, err := listRecordsOfTarget(ctx, xrpcc, did, kind.collection, kind.actionType, cfg.TargetDID)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, found...)
+	}
+	return records, nil
+}
+
+// listRecordsOfTarget pages through collection in repo and keeps the records whose subject is authored by targetDID.
+func listRecordsOfTarget(ctx context.Context, xrpcc *xrpc.Client, repo, collection, actionType, targetDID string) ([]ActionedRecord, error) {
+	prefix := "at://" + targetDID + "/"
+	var records []ActionedRecord
+	cursor := ""
+	for {
+		out, err := atproto.RepoListRecords(ctx, xrpcc, collection, cursor, 100, repo, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s records: %w", collection, err)
+		}
+		for _, rec := range out.Records {
+			if rec.Value == nil {
+				continue
+			}
+			var subject *atproto.RepoStrongRef
+			var createdAt string
+			switch v := rec.Value.Val.(type) {
+			case *bsky.FeedLike:
+				subject, createdAt = v.Subject, v.CreatedAt
+			case *bsky.FeedRepost:
+				subject, createdAt = v.Subject, v.CreatedAt
+			default:
+				slog.Debug("Skipping record of unexpected type", "recordUri", rec.Uri)
+				continue
+			}
+			if subject == nil || !strings.HasPrefix(subject.Uri, prefix) {
+				continue
+			}
+			records = append(records, ActionedRecord{Type: actionType, RecordURI: rec.Uri, SubjectURI: subject.Uri, CreatedAt: createdAt})
+		}
+		if out.Cursor == nil || *out.Cursor == "" || len(out.Records) == 0 {
+			return records, nil
+		}
+		cursor = *out.Cursor
+	}
+}
+
+// maxEngagementRecords bounds how many of the account's own posts engagedPosts reads, newest first.
+const maxEngagementRecords = 1000
+
+// ownEngagement holds the URIs of the posts that the posts of a repo reply to and quote.
+type ownEngagement struct {
+	replied map[string]bool
+	quoted  map[string]bool
+}
+
+// byPost maps the URI of every post engaged with to how: "replied", "quoted" or "replied and quoted".
+func (e ownEngagement) byPost() map[string]string {
+	engaged := make(map[string]string, len(e.replied)+len(e.quoted))
+	for uri := range e.replied {
+		engaged[uri] = "replied"
+	}
+	for uri := range e.quoted {
+		if engaged[uri] != "" {
+			engaged[uri] = "replied and quoted"
+		} else {
+			engaged[uri] = "quoted"
+		}
+	}
+	return engaged
+}
+
+// engagedPosts returns the URIs of the posts that the newest posts in repo reply to or quote.
+func engagedPosts(ctx context.Context, xrpcc *xrpc.Client, repo string) (ownEngagement, error) {
+	engaged := ownEngagement{replied: make(map[string]bool), quoted: make(map[string]bool)}
+	cursor := ""
+	for read := 0; read < maxEngagementRecords; {
+		out, err := atproto.RepoListRecords(ctx, xrpcc, "app.bsky.feed.post", cursor, 100, repo, false)
+		if err != nil {
+			return engaged, fmt.Errorf("failed to list own posts: %w", err)
+		}
+		read += len(out.Records)
+		for _, rec := range out.Records {
+			if rec.Value == nil {
+				continue
+			}
+			post, ok := rec.Value.Val.(*bsky.FeedPost)
+			if !ok {
+				continue
+			}
+			if post.Reply != nil && post.Reply.Parent != nil {
+				engaged.replied[post.Reply.Parent.Uri] = true
+			}
+			if uri := quotedURI(post); uri != "" {
+				engaged.quoted[uri] = true
+			}
+		}
+		if out.Cursor == nil || *out.Cursor == "" || len(out.Records) == 0 {
+			break
+		}
+		cursor = *out.Cursor
+	}
+	return engaged, nil
+}
+
+// quotedURI returns the URI of the record quoted by post, or "" if it quotes nothing.
+func quotedURI(post *bsky.FeedPost) string {
+	if post.Embed == nil {
+		return ""
+	}
+	switch {
+	case post.Embed.EmbedRecord != nil && post.Embed.EmbedRecord.Record != nil:
+		return post.Embed.EmbedRecord.Record.Uri
+	case post.Embed.EmbedRecordWithMedia != nil && post.Embed.EmbedRecordWithMedia.Record != nil &&
+		post.Embed.EmbedRecordWithMedia.Record.Record != nil:
+		return post.Embed.EmbedRecordWithMedia.Record.Record.Uri
+	}
+	return ""
+}
@@ -0,0 +1,328 @@
+package reposter
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// OAuthConfig holds the pre-provisioned credentials of a confidential atproto OAuth client.
+// Only the refresh path is supported: the initial authorization must be done beforehand and
+// its refresh token stored in TokenFile.
+type OAuthConfig struct {
+	ClientID      string // URL of the client metadata document
+	ClientKeyFile string // PEM file with the ES256 private key used for private_key_jwt client authentication
+	ClientKeyID   string // Key ID ("kid") of the client key in the client's JWKS
+	DPoPKeyFile   string // PEM file with the ES256 private key the tokens are bound to
+	TokenFile     string // JSON file holding the refresh token; rewritten with the rotated token after each refresh
+}
+
+// Validate checks that every OAuth option is set.
+func (c OAuthConfig) Validate() error {
+	switch {
+	case c.ClientID == "":
+		return fmt.Errorf("OAuth client ID is required")
+	case c.ClientKeyFile == "":
+		return fmt.Errorf("OAuth client key file is required")
+	case c.DPoPKeyFile == "":
+		return fmt.Errorf("OAuth DPoP key file is required")
+	case c.TokenFile == "":
+		return fmt.Errorf("OAuth token file is required")
+	}
+	return nil
+}
+
+// oauthTokens is the content of OAuthConfig.TokenFile.
+type oauthTokens struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// AuthenticateOAuth exchanges the stored refresh token for DPoP-bound tokens at the
+// authorization server of xrpcc's PDS, persists the rotated refresh token and configures
+// xrpcc to send the access token. xrpcc must have been created with a DPoP key
+// (see ClientOptions.DPoPKey) matching cfg.DPoPKeyFile.
+func AuthenticateOAuth(ctx context.Context, xrpcc *xrpc.Client, cfg OAuthConfig) (*atproto.ServerGetSession_Output, error) {
+	clientKey, err := LoadECKey(cfg.ClientKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(cfg.TokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OAuth token file %s: %w", cfg.TokenFile, err)
+	}
+	var stored oauthTokens
+	if err := json.Unmarshal(data, &stored); err != nil || stored.RefreshToken == "" {
+		return nil, fmt.Errorf("OAuth token file %s has no refresh token", cfg.TokenFile)
+	}
+
+	issuer, tokenEndpoint, err := discoverAuthorizationServer(ctx, xrpcc.Client, xrpcc.Host)
+	if err != nil {
+		return nil, err
+	}
+	assertion, err := signJWT(clientKey, map[string]any{"alg": "ES256", "kid": cfg.ClientKeyID}, map[string]any{
+		"iss": cfg.ClientID,
+		"sub": cfg.ClientID,
+		"aud": issuer,
+		"jti": randomID(),
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Minute).Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":            {"refresh_token"},
+		"refresh_token":         {stored.RefreshToken},
+		"client_id":             {cfg.ClientID},
+		"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
+		"client_assertion":      {assertion},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := xrpcc.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to refresh OAuth token: %w", err)
+	}
+	defer resp.Body.Close()
+	var token struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		RefreshToken string `json:"refresh_token"`
+		Sub          string `json:"sub"`
+		Error        string `json:"error"`
+		Description  string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, fmt.Errorf("failed to decode OAuth token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to refresh OAuth token: HTTP %d: %s %s", resp.StatusCode, token.Error, token.Description)
+	}
+	if !strings.EqualFold(token.TokenType, "DPoP") {
+		return nil, fmt.Errorf("unexpected OAuth token type %q, expected DPoP", token.TokenType)
+	}
+
+	// The old refresh token is now spent: persist the new one before anything else can fail.
+	if err := writeFileAtomic(cfg.TokenFile, oauthTokens{RefreshToken: token.RefreshToken}); err != nil {
+		return nil, err
+	}
+
+	xrpcc.Auth = &xrpc.AuthInfo{AccessJwt: token.AccessToken, Did: token.Sub}
+	session, err := atproto.ServerGetSession(ctx, xrpcc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get OAuth session: %w", err)
+	}
+	xrpcc.Auth.Handle = session.Handle
+	return session, nil
+}
+
+// discoverAuthorizationServer returns the issuer and token endpoint of the authorization server protecting pds.
+func discoverAuthorizationServer(ctx context.Context, c *http.Client, pds string) (issuer, tokenEndpoint string, err error) {
+	var resource struct {
+		AuthorizationServers []string `json:"authorization_servers"`
+	}
+	if err := getJSON(ctx, c, strings.TrimSuffix(pds, "/")+"/.well-known/oauth-protected-resource", &resource); err != nil {
+		return "", "", err
+	}
+	if len(resource.AuthorizationServers) == 0 {
+		return "", "", fmt.Errorf("PDS %s lists no OAuth authorization server", pds)
+	}
+	var server struct {
+		Issuer        string `json:"issuer"`
+		TokenEndpoint string `json:"token_endpoint"`
+	}
+	if err := getJSON(ctx, c, strings.TrimSuffix(resource.AuthorizationServers[0], "/")+"/.well-known/oauth-authorization-server", &server); err != nil {
+		return "", "", err
+	}
+	if server.TokenEndpoint == "" {
+		return "", "", fmt.Errorf("authorization server %s has no token endpoint", server.Issuer)
+	}
+	return server.Issuer, server.TokenEndpoint, nil
+}
+
+// getJSON fetches u and decodes its JSON body into out.
+func getJSON(ctx context.Context, c *http.Client, u string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s: %w", u, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch %s: HTTP %d", u, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", u, err)
+	}
+	return nil
+}
+
+// LoadECKey reads a P-256 private key from a PEM file in SEC 1 or PKCS #8 form.
+func LoadECKey(path string) (*ecdsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file %s: %w", path, err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found in key file %s", path)
+	}
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		parsed, pkcs8Err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if pkcs8Err != nil {
+			return nil, fmt.Errorf("failed to parse key file %s: %w", path, err)
+		}
+		var ok bool
+		if key, ok = parsed.(*ecdsa.PrivateKey); !ok {
+			return nil, fmt.Errorf("key file %s does not hold an EC private key", path)
+		}
+	}
+	if key.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("key file %s does not hold a P-256 key", path)
+	}
+	return key, nil
+}
+
+// dpopTransport is an http.RoundTripper adding a DPoP proof to every request and turning
+// "Bearer" authorization headers into DPoP-bound ones. Server-provided nonces are
+// remembered per origin and a request rejected for a missing or stale nonce is retried once.
+type dpopTransport struct {
+	next http.RoundTripper
+	key  *ecdsa.PrivateKey
+
+	mu     sync.Mutex
+	nonces map[string]string // By scheme://host
+}
+
+func (t *dpopTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	origin := req.URL.Scheme + "://" + req.URL.Host
+
+	var resp *http.Response
+	for attempt := 0; attempt < 2; attempt++ {
+		t.mu.Lock()
+		nonce := t.nonces[origin]
+		t.mu.Unlock()
+
+		r := req.Clone(req.Context())
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+		claims := map[string]any{
+			"jti": randomID(),
+			"htm": r.Method,
+			"htu": origin + r.URL.Path,
+			"iat": time.Now().Unix(),
+		}
+		if nonce != "" {
+			claims["nonce"] = nonce
+		}
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			sum := sha256.Sum256([]byte(token))
+			claims["ath"] = base64.RawURLEncoding.EncodeToString(sum[:])
+			r.Header.Set("Authorization", "DPoP "+token)
+		}
+		proof, err := signJWT(t.key, map[string]any{"typ": "dpop+jwt", "alg": "ES256", "jwk": publicJWK(&t.key.PublicKey)}, claims)
+		if err != nil {
+			return nil, err
+		}
+		r.Header.Set("DPoP", proof)
+
+		resp, err = t.next.RoundTrip(r)
+		if err != nil {
+			return nil, err
+		}
+		newNonce := resp.Header.Get("DPoP-Nonce")
+		if newNonce == "" || newNonce == nonce {
+			return resp, nil
+		}
+		t.mu.Lock()
+		if t.nonces == nil {
+			t.nonces = make(map[string]string)
+		}
+		t.nonces[origin] = newNonce
+		t.mu.Unlock()
+		if resp.StatusCode != http.StatusBadRequest && resp.StatusCode != http.StatusUnauthorized {
+			return resp, nil
+		}
+		if attempt == 0 {
+			resp.Body.Close()
+		}
+	}
+	return resp, nil
+}
+
+// signJWT returns the compact ES256 serialization of a JWT with the given header and claims.
+func signJWT(key *ecdsa.PrivateKey, header, claims map[string]any) (string, error) {
+	h, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	input := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	digest := sha256.Sum256([]byte(input))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign JWT: %w", err)
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// publicJWK returns the JWK representation of a P-256 public key.
+func publicJWK(pub *ecdsa.PublicKey) map[string]string {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	pub.X.FillBytes(x)
+	pub.Y.FillBytes(y)
+	return map[string]string{
+		"kty": "EC",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(x),
+		"y":   base64.RawURLEncoding.EncodeToString(y),
+	}
+}
+
+// randomID returns a random URL-safe identifier suitable for a JWT "jti" claim.
+func randomID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
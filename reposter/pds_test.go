@@ -0,0 +1,314 @@
+package reposter
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/lex/util"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// The fixtures below stand in for a PDS, so runs can be tested end to end without a network.
+
+const (
+	testDID    = "did:plc:me"     // Account the fake PDS authenticates
+	testTarget = "did:plc:target" // Target whose feed the fake PDS serves
+)
+
+// testEpoch is the indexedAt of post 0 of the fixtures; post n is indexed n minutes later.
+var testEpoch = time.Date(2026, 10, 16, 10, 0, 0, 0, time.UTC)
+
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+	os.Exit(m.Run())
+}
+
+// fakePage is a page of an author feed, served for Cursor and pointing to Next.
+type fakePage struct {
+	Cursor string
+	Next   string
+	Posts  []*bsky.FeedDefs_PostView
+
+	// Items, when set, are served instead of Posts, e.g. to carry thread context.
+	Items []*bsky.FeedDefs_FeedViewPost
+}
+
+// createdRecord is a com.atproto.repo.createRecord call received by the fake PDS.
+type createdRecord struct {
+	Repo       string          `json:"repo"`
+	Collection string          `json:"collection"`
+	Record     json.RawMessage `json:"record"`
+}
+
+// subject returns the URI of the post a like or repost record refers to.
+func (c createdRecord) subject() string {
+	var record struct {
+		Subject struct {
+			URI string `json:"uri"`
+		} `json:"subject"`
+	}
+	json.Unmarshal(c.Record, &record)
+	return record.Subject.URI
+}
+
+// fakePDS is an httptest.Server answering the XRPC calls of a run from canned fixtures:
+// createSession, refreshSession, getAuthorFeed, getActorLikes, searchPosts, getPosts, listRecords
+// and createRecord.
+// Other methods fail with MethodNotImplemented.
+type fakePDS struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	feeds   map[string]map[string]fakePage // Author feed pages by actor and cursor
+	likes   map[string]map[string]fakePage // Liked posts pages by actor and cursor
+	search  map[string]fakePage            // Search result pages by cursor, whatever the query
+	posts   map[string]*bsky.FeedDefs_PostView
+	created []createdRecord
+	calls   map[string]int
+	queries []url.Values // Parameters of the feed calls, in order
+
+	// AccessJwt is the access token handed out by createSession and refreshSession.
+	AccessJwt string
+
+	// CreateRecordBody, when set, is the raw body of every createRecord response.
+	CreateRecordBody *string
+}
+
+// newFakePDS starts a fake PDS, closed when tb ends.
+func newFakePDS(tb testing.TB) *fakePDS {
+	tb.Helper()
+	f := &fakePDS{
+		feeds:     make(map[string]map[string]fakePage),
+		likes:     make(map[string]map[string]fakePage),
+		posts:     make(map[string]*bsky.FeedDefs_PostView),
+		calls:     make(map[string]int),
+		AccessJwt: fakeJWT(time.Now().Add(time.Hour)),
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	tb.Cleanup(f.Close)
+	return f
+}
+
+// client returns a client of the fake PDS authenticated as testDID.
+func (f *fakePDS) client() *xrpc.Client {
+	return &xrpc.Client{Host: f.URL, Client: f.Server.Client(), Auth: &xrpc.AuthInfo{AccessJwt: f.AccessJwt, RefreshJwt: "refresh", Did: testDID, Handle: "me.test"}}
+}
+
+// feed serves pages as the author feed of actor, and their posts to getPosts.
+func (f *fakePDS) feed(actor string, pages ...fakePage) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.feeds[actor] = f.byCursor(pages)
+}
+
+// likedPosts serves pages as the posts liked by actor, and their posts to getPosts.
+func (f *fakePDS) likedPosts(actor string, pages ...fakePage) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.likes[actor] = f.byCursor(pages)
+}
+
+// searchResults serves pages as the results of every search, and their posts to getPosts.
+func (f *fakePDS) searchResults(pages ...fakePage) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.search = f.byCursor(pages)
+}
+
+// byCursor indexes pages by cursor and registers their posts for getPosts.
+func (f *fakePDS) byCursor(pages []fakePage) map[string]fakePage {
+	byCursor := make(map[string]fakePage, len(pages))
+	for _, page := range pages {
+		byCursor[page.Cursor] = page
+		for _, post := range page.Posts {
+			f.posts[post.Uri] = post
+		}
+		for _, item := range page.Items {
+			f.posts[item.Post.Uri] = item.Post
+		}
+	}
+	return byCursor
+}
+
+// chain returns the pages holding posts, served in turn with the cursors c1, c2 and so on.
+func chain(posts ...[]*bsky.FeedDefs_PostView) []fakePage {
+	pages := make([]fakePage, len(posts))
+	for i, page := range posts {
+		pages[i] = fakePage{Posts: page}
+		if i > 0 {
+			pages[i].Cursor = fmt.Sprintf("c%d", i)
+		}
+		if i < len(posts)-1 {
+			pages[i].Next = fmt.Sprintf("c%d", i+1)
+		}
+	}
+	return pages
+}
+
+// records returns the records created so far, in order.
+func (f *fakePDS) records() []createdRecord {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]createdRecord(nil), f.created...)
+}
+
+// fetchedCursors returns the cursors of the getAuthorFeed and getActorLikes calls received so far, in order.
+func (f *fakePDS) fetchedCursors() []string {
+	return f.feedParams("cursor")
+}
+
+// feedParams returns the values of the parameter name of the getAuthorFeed and getActorLikes
+// calls received so far, in order.
+func (f *fakePDS) feedParams(name string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	values := make([]string, 0, len(f.queries))
+	for _, q := range f.queries {
+		values = append(values, q.Get(name))
+	}
+	return values
+}
+
+// callCount returns how many times the XRPC method nsid was called.
+func (f *fakePDS) callCount(nsid string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[nsid]
+}
+
+func (f *fakePDS) serve(w http.ResponseWriter, req *http.Request) {
+	nsid := strings.TrimPrefix(req.URL.Path, "/xrpc/")
+	q := req.URL.Query()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls[nsid]++
+	switch nsid {
+	case "com.atproto.server.createSession", "com.atproto.server.refreshSession":
+		writeJSON(w, map[string]any{"accessJwt": f.AccessJwt, "refreshJwt": "refresh", "handle": "me.test", "did": testDID})
+	case "app.bsky.feed.getAuthorFeed", "app.bsky.feed.getActorLikes":
+		f.queries = append(f.queries, q)
+		pages := f.feeds
+		if nsid == "app.bsky.feed.getActorLikes" {
+			pages = f.likes
+		}
+		page := pages[q.Get("actor")][q.Get("cursor")]
+		feed := page.Items
+		if feed == nil {
+			feed = make([]*bsky.FeedDefs_FeedViewPost, 0, len(page.Posts))
+			for _, post := range page.Posts {
+				feed = append(feed, &bsky.FeedDefs_FeedViewPost{Post: post})
+			}
+		}
+		out := map[string]any{"feed": feed}
+		if page.Next != "" {
+			out["cursor"] = page.Next
+		}
+		writeJSON(w, out)
+	case "app.bsky.feed.searchPosts":
+		page := f.search[q.Get("cursor")]
+		out := map[string]any{"posts": append([]*bsky.FeedDefs_PostView{}, page.Posts...)}
+		if page.Next != "" {
+			out["cursor"] = page.Next
+		}
+		writeJSON(w, out)
+	case "app.bsky.feed.getPosts":
+		posts := []*bsky.FeedDefs_PostView{}
+		for _, uri := range q["uris"] {
+			if post, ok := f.posts[uri]; ok {
+				posts = append(posts, post)
+			}
+		}
+		writeJSON(w, map[string]any{"posts": posts})
+	case "com.atproto.repo.listRecords":
+		writeJSON(w, map[string]any{"records": []any{}})
+	case "com.atproto.repo.createRecord":
+		var c createdRecord
+		body, _ := io.ReadAll(req.Body)
+		if err := json.Unmarshal(body, &c); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, map[string]any{"error": "InvalidRequest", "message": err.Error()})
+			return
+		}
+		f.created = append(f.created, c)
+		if f.CreateRecordBody != nil {
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, *f.CreateRecordBody)
+			return
+		}
+		writeJSON(w, map[string]any{
+			"uri": fmt.Sprintf("at://%s/%s/%d", c.Repo, c.Collection, len(f.created)),
+			"cid": fmt.Sprintf("bafyrecord%d", len(f.created)),
+		})
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+		writeJSON(w, map[string]any{"error": "MethodNotImplemented", "message": nsid})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// fakeJWT returns an unsigned JWT expiring at exp.
+func fakeJWT(exp time.Time) string {
+	enc := base64.RawURLEncoding
+	claims := fmt.Sprintf(`{"sub":%q,"exp":%d}`, testDID, exp.Unix())
+	return enc.EncodeToString([]byte(`{"alg":"none"}`)) + "." + enc.EncodeToString([]byte(claims)) + ".sig"
+}
+
+// testPost returns post n of author, indexed n minutes after testEpoch, neither liked nor reposted.
+func testPost(author string, n int) *bsky.FeedDefs_PostView {
+	at := FormatTimestamp(testEpoch.Add(time.Duration(n) * time.Minute))
+	return &bsky.FeedDefs_PostView{
+		Uri:       fmt.Sprintf("at://%s/app.bsky.feed.post/%d", author, n),
+		Cid:       fmt.Sprintf("bafypost%d", n),
+		Author:    &bsky.ActorDefs_ProfileViewBasic{Did: author, Handle: "author.test"},
+		Record:    &util.LexiconTypeDecoder{Val: &bsky.FeedPost{Text: fmt.Sprintf("post %d", n), CreatedAt: at}},
+		IndexedAt: at,
+		Viewer:    &bsky.FeedDefs_ViewerState{},
+	}
+}
+
+// actioned marks post as already liked and reposted by the authenticated account.
+func actioned(post *bsky.FeedDefs_PostView) *bsky.FeedDefs_PostView {
+	like, repost := post.Uri+"/like", post.Uri+"/repost"
+	post.Viewer = &bsky.FeedDefs_ViewerState{Like: &like, Repost: &repost}
+	return post
+}
+
+// testConfig returns the configuration of a run against pds, actioning one post of testTarget.
+func testConfig(pds *fakePDS) Config {
+	return Config{
+		Handle:    "me.test",
+		Password:  "password",
+		PDSHost:   pds.URL,
+		TargetDID: testTarget,
+		PageDelay: -1,
+	}
+}
+
+// actionedSubjects returns the subjects of the like and repost records created, by collection.
+func actionedSubjects(records []createdRecord) (likes, reposts []string) {
+	for _, c := range records {
+		switch c.Collection {
+		case "app.bsky.feed.like":
+			likes = append(likes, c.subject())
+		case "app.bsky.feed.repost":
+			reposts = append(reposts, c.subject())
+		}
+	}
+	return likes, reposts
+}
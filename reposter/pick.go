@@ -0,0 +1,197 @@
+package reposter
+
+import (
+	"cmp"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+)
+
+// PickStrategy orders eligible posts, given oldest first, by preference. The first Count posts of the result are actioned.
+type PickStrategy func(posts []*bsky.FeedDefs_PostView) []*bsky.FeedDefs_PostView
+
+// PickStrategies maps --pick names to their implementation. New strategies only need to be registered here.
+var PickStrategies = map[string]PickStrategy{
+	OrderOldest: func(posts []*bsky.FeedDefs_PostView) []*bsky.FeedDefs_PostView {
+		return posts
+	},
+	OrderNewest: func(posts []*bsky.FeedDefs_PostView) []*bsky.FeedDefs_PostView {
+		newest := slices.Clone(posts)
+		slices.Reverse(newest)
+		return newest
+	},
+	"most-liked": byCountDesc(func(post *bsky.FeedDefs_PostView) int64 {
+		return countOrZero(post.LikeCount)
+	}),
+	"most-reposted": byCountDesc(func(post *bsky.FeedDefs_PostView) int64 {
+		return countOrZero(post.RepostCount)
+	}),
+	"has-media": func(posts []*bsky.FeedDefs_PostView) []*bsky.FeedDefs_PostView {
+		sorted := slices.Clone(posts)
+		slices.SortStableFunc(sorted, func(a, b *bsky.FeedDefs_PostView) int {
+			// Posts with media sort first; ties keep their oldest-first order.
+			return boolDesc(hasMedia(a), hasMedia(b))
+		})
+		return sorted
+	},
+}
+
+// Thread picks for DedupThreads.
+const (
+	ThreadPickRoot        = "root"         // Keep the thread root, or its oldest eligible member when the root is not eligible
+	ThreadPickMostEngaged = "most-engaged" // Keep the member with the most likes plus reposts
+)
+
+// threadRoot returns the URI of the root of the thread post belongs to, which is its own URI for a top-level post.
+func threadRoot(post *bsky.FeedDefs_PostView) string {
+	if record := postRecord(post); record != nil && record.Reply != nil && record.Reply.Root != nil {
+		return record.Reply.Root.Uri
+	}
+	return post.Uri
+}
+
+// isReplyTo reports whether post replies to a post, or in a thread, authored by did.
+func isReplyTo(post *bsky.FeedDefs_PostView, did string) bool {
+	record := postRecord(post)
+	if record == nil || record.Reply == nil {
+		return false
+	}
+	prefix := "at://" + did + "/"
+	for _, ref := range []*atproto.RepoStrongRef{record.Reply.Parent, record.Reply.Root} {
+		if ref != nil && strings.HasPrefix(ref.Uri, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// DedupThreads keeps a single post per thread among posts, given oldest first, chosen by pick.
+// The posts kept stay in their original order; the others are logged as collapsed.
+func DedupThreads(posts []*bsky.FeedDefs_PostView, pick string) []*bsky.FeedDefs_PostView {
+	kept := make(map[string]*bsky.FeedDefs_PostView)
+	for _, post := range posts {
+		root := threadRoot(post)
+		current, ok := kept[root]
+		switch {
+		case !ok:
+			kept[root] = post
+		case pick == ThreadPickMostEngaged:
+			if engagement(post) > engagement(current) {
+				kept[root] = post
+			}
+		case post.Uri == root:
+			kept[root] = post
+		}
+	}
+
+	var deduped []*bsky.FeedDefs_PostView
+	for _, post := range posts {
+		root := threadRoot(post)
+		if kept[root] == post {
+			deduped = append(deduped, post)
+			continue
+		}
+		slog.Info("Collapsing thread member, another post of the thread is actioned instead",
+			"postUri", post.Uri,
+			"threadRoot", root,
+			"keptUri", kept[root].Uri,
+			"threadPick", pick,
+		)
+	}
+	return deduped
+}
+
+// engagement returns the number of likes plus reposts of post.
+func engagement(post *bsky.FeedDefs_PostView) int64 {
+	return countOrZero(post.LikeCount) + countOrZero(post.RepostCount)
+}
+
+// Shuffle returns a copy of posts in a random order determined by seed.
+func Shuffle(posts []*bsky.FeedDefs_PostView, seed uint64) []*bsky.FeedDefs_PostView {
+	shuffled := slices.Clone(posts)
+	r := rand.New(rand.NewPCG(seed, seed))
+	r.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+	return shuffled
+}
+
+// SortPosts sorts posts oldest first by the chosen timestamp (SortIndexedAt or SortCreatedAt).
+// Posts whose createdAt is missing or malformed fall back to their indexedAt.
+func SortPosts(posts []*bsky.FeedDefs_PostView, sortBy string) {
+	keys := make(map[*bsky.FeedDefs_PostView]time.Time, len(posts))
+	for _, post := range posts {
+		keys[post] = postTime(post, sortBy)
+	}
+	slices.SortStableFunc(posts, func(a, b *bsky.FeedDefs_PostView) int {
+		return keys[a].Compare(keys[b])
+	})
+}
+
+// postTime returns the post's timestamp used for sorting.
+func postTime(post *bsky.FeedDefs_PostView, sortBy string) time.Time {
+	if sortBy == SortCreatedAt {
+		if record := postRecord(post); record != nil {
+			if t, err := ParseTimestamp(record.CreatedAt); err == nil {
+				return t
+			}
+		}
+		slog.Debug("Post has no valid createdAt, falling back to indexedAt", "postUri", post.Uri)
+	}
+	t, err := ParseTimestamp(post.IndexedAt)
+	if err != nil {
+		slog.Debug("Post has no valid indexedAt", "postUri", post.Uri, "indexedAt", post.IndexedAt)
+	}
+	return t
+}
+
+// byCountDesc returns a strategy sorting posts by count, highest first. Ties keep their oldest-first order.
+func byCountDesc(count func(*bsky.FeedDefs_PostView) int64) PickStrategy {
+	return func(posts []*bsky.FeedDefs_PostView) []*bsky.FeedDefs_PostView {
+		sorted := slices.Clone(posts)
+		slices.SortStableFunc(sorted, func(a, b *bsky.FeedDefs_PostView) int {
+			return cmp.Compare(count(b), count(a))
+		})
+		return sorted
+	}
+}
+
+// boolDesc compares two booleans so that true sorts before false.
+func boolDesc(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return -1
+	default:
+		return 1
+	}
+}
+
+// hasMedia reports whether the post embeds images or video, directly or alongside a quoted record.
+// The hydrated embed view is checked first, then the embed of the post record.
+func hasMedia(post *bsky.FeedDefs_PostView) bool {
+	if embed := post.Embed; embed != nil {
+		if embed.EmbedImages_View != nil || embed.EmbedVideo_View != nil {
+			return true
+		}
+		if rwm := embed.EmbedRecordWithMedia_View; rwm != nil && rwm.Media != nil &&
+			(rwm.Media.EmbedImages_View != nil || rwm.Media.EmbedVideo_View != nil) {
+			return true
+		}
+	}
+	record := postRecord(post)
+	if record == nil || record.Embed == nil {
+		return false
+	}
+	if record.Embed.EmbedImages != nil || record.Embed.EmbedVideo != nil {
+		return true
+	}
+	rwm := record.Embed.EmbedRecordWithMedia
+	return rwm != nil && rwm.Media != nil && (rwm.Media.EmbedImages != nil || rwm.Media.EmbedVideo != nil)
+}
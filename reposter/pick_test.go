@@ -0,0 +1,73 @@
+package reposter
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+)
+
+// createdAt sets the createdAt of post's record to n minutes after testEpoch.
+func createdAt(post *bsky.FeedDefs_PostView, n int) *bsky.FeedDefs_PostView {
+	postRecord(post).CreatedAt = FormatTimestamp(testEpoch.Add(time.Duration(n) * time.Minute))
+	return post
+}
+
+// postURIs returns the URIs of posts, in order.
+func postURIs(posts []*bsky.FeedDefs_PostView) []string {
+	var out []string
+	for _, post := range posts {
+		out = append(out, post.Uri)
+	}
+	return out
+}
+
+func TestSortPosts(t *testing.T) {
+	// Post 3 was created first but indexed last, e.g. an old post indexed late.
+	old := createdAt(testPost(testTarget, 3), 0)
+	malformed := testPost(testTarget, 2)
+	postRecord(malformed).CreatedAt = "yesterday"
+	posts := []*bsky.FeedDefs_PostView{old, malformed, testPost(testTarget, 1)}
+
+	byIndexedAt := slices.Clone(posts)
+	SortPosts(byIndexedAt, SortIndexedAt)
+	if want := []string{testPost(testTarget, 1).Uri, malformed.Uri, old.Uri}; !slices.Equal(postURIs(byIndexedAt), want) {
+		t.Errorf("SortPosts by indexedAt = %v, want %v", postURIs(byIndexedAt), want)
+	}
+
+	// The malformed createdAt falls back to the indexedAt.
+	byCreatedAt := slices.Clone(posts)
+	SortPosts(byCreatedAt, SortCreatedAt)
+	if want := []string{old.Uri, testPost(testTarget, 1).Uri, malformed.Uri}; !slices.Equal(postURIs(byCreatedAt), want) {
+		t.Errorf("SortPosts by createdAt = %v, want %v", postURIs(byCreatedAt), want)
+	}
+}
+
+func TestRunSortsPinnedPostByCreatedAt(t *testing.T) {
+	pds := newFakePDS(t)
+	// The pinned post, created first but reindexed recently, heads the first page and comes
+	// back in its place on the next one.
+	pinned := func() *bsky.FeedDefs_PostView { return createdAt(testPost(testTarget, 5), 0) }
+	pds.feed(testTarget, chain(
+		[]*bsky.FeedDefs_PostView{pinned(), testPost(testTarget, 4), testPost(testTarget, 3)},
+		[]*bsky.FeedDefs_PostView{testPost(testTarget, 2), pinned(), testPost(testTarget, 1)},
+	)...)
+
+	cfg := testConfig(pds)
+	cfg.Count = 2
+	cfg.SortBy = SortCreatedAt
+	result, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	want := []string{pinned().Uri, testPost(testTarget, 1).Uri}
+	if !slices.Equal(result.ActionedURIs, want) {
+		t.Errorf("Run actioned %v, want %v", result.ActionedURIs, want)
+	}
+	likes, reposts := actionedSubjects(pds.records())
+	if !slices.Equal(likes, want) || !slices.Equal(reposts, want) {
+		t.Errorf("Run liked %v and reposted %v, want %v for both", likes, reposts, want)
+	}
+}
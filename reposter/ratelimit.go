@@ -0,0 +1,109 @@
+package reposter
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WriteLimiter spaces out write operations (likes, reposts, curation records) so that
+// consecutive writes start at least Interval apart. It is safe for concurrent use; a nil
+// *WriteLimiter never waits.
+type WriteLimiter struct {
+	Interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// NewWriteLimiter returns a limiter allowing one write every interval, or nil when interval is not positive.
+func NewWriteLimiter(interval time.Duration) *WriteLimiter {
+	if interval <= 0 {
+		return nil
+	}
+	return &WriteLimiter{Interval: interval}
+}
+
+// Wait blocks until the next write may start or ctx is done.
+func (l *WriteLimiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(l.Interval)
+	l.mu.Unlock()
+
+	if delay := time.Until(at); delay > 0 {
+		return sleepCtx(ctx, delay)
+	}
+	return nil
+}
+
+// Estimate returns the minimum wall-clock time the limiter needs to let the given number of writes through.
+func (l *WriteLimiter) Estimate(writes int) time.Duration {
+	if l == nil || writes <= 1 {
+		return 0
+	}
+	return time.Duration(writes-1) * l.Interval
+}
+
+// Rate is a number of posts per period, such as 5 per hour.
+type Rate struct {
+	N   int
+	Per time.Duration
+}
+
+// ParseRate parses a rate written as N/duration, e.g. "5/1h".
+func ParseRate(s string) (Rate, error) {
+	n, per, ok := strings.Cut(s, "/")
+	if !ok {
+		return Rate{}, fmt.Errorf("invalid rate %q, expected N/duration such as 5/1h", s)
+	}
+	count, err := strconv.Atoi(n)
+	if err != nil || count < 1 {
+		return Rate{}, fmt.Errorf("invalid rate %q, N must be a positive integer", s)
+	}
+	d, err := time.ParseDuration(per)
+	if err != nil || d <= 0 {
+		return Rate{}, fmt.Errorf("invalid rate %q, duration must be positive", s)
+	}
+	return Rate{N: count, Per: d}, nil
+}
+
+// String formats the rate as N/duration.
+func (r Rate) String() string {
+	return fmt.Sprintf("%d/%s", r.N, r.Per)
+}
+
+// Spacing returns the delay between two posts that spreads N posts evenly over the period.
+func (r Rate) Spacing() time.Duration {
+	return r.Per / time.Duration(r.N)
+}
+
+// withTimeout returns a context bounded by d, or ctx itself when d is not positive.
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, d)
+}
+
+// sleepCtx waits for d or until ctx is done.
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
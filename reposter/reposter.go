@@ -0,0 +1,668 @@
+// Package reposter likes and reposts posts from a target Bluesky account.
+//
+// It is the core of the bs-reposter-liker command and can be embedded in other
+// Go programs through Run, or Plan and Apply to review the selection before acting on it.
+package reposter
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+const (
+	BlueskyPDS = "https://bsky.social" // The default PDS for Bluesky
+
+	SourceAuthor = "author" // Read posts authored by the target
+	SourceLikes  = "likes"  // Read posts liked by the target
+
+	OrderOldest = "oldest" // Action the oldest eligible posts first
+	OrderNewest = "newest" // Action the newest eligible posts first
+
+	SortIndexedAt = "indexedAt" // Order posts by when the AppView indexed them
+	SortCreatedAt = "createdAt" // Order posts by the creation time in their record
+
+	AuthorFilterWithReplies   = "posts_with_replies"       // Posts and replies; the server default
+	AuthorFilterNoReplies     = "posts_no_replies"         // Top-level posts only
+	AuthorFilterWithMedia     = "posts_with_media"         // Posts with images or video
+	AuthorFilterAuthorThreads = "posts_and_author_threads" // Top-level posts and the target's replies in their own threads
+	AuthorFilterWithVideo     = "posts_with_video"         // Posts with video
+
+	QuoteFromExternalTitle = "external-title" // Quote posts with the title of the quoted post's link card
+)
+
+// authorFilters lists the accepted values of Config.AuthorFilter.
+var authorFilters = []string{AuthorFilterWithReplies, AuthorFilterNoReplies, AuthorFilterWithMedia, AuthorFilterAuthorThreads, AuthorFilterWithVideo}
+
+// Config holds all the options for a run.
+type Config struct {
+	Handle          string // Handle or DID used to log in
+	Password        string // App password for Handle
+	AuthFactorToken string // Email sign-in code for accounts with two-factor authentication
+	AuthAttempts    int    // Maximum session creation attempts; values below 1 mean a single attempt
+	UserAgent       string // User-Agent header sent with every request; empty keeps the library default
+	PDSHost         string // URL of the PDS to log in to; defaults to BlueskyPDS
+
+	// RetryableErrors lists the XRPC error names that are retried; errors without a name, such
+	// as network failures, always are. Nil means DefaultRetryableErrors.
+	RetryableErrors []string
+
+	// SessionFile, when set, caches the app password session between runs: it is refreshed
+	// instead of creating a new session, which is rate limited more strictly. With SessionKey
+	// it is encrypted with AES-256-GCM; a file that cannot be read or decrypted is ignored.
+	SessionFile string
+	SessionKey  string
+
+	// RefreshMargin, when positive, refreshes the app password session before the action phase,
+	// and after each catch-up wait, when its access token expires within this margin, so the
+	// next write does not fail on an expired token.
+	RefreshMargin time.Duration
+
+	// StartupWait is how long authentication keeps being retried, with backoff, while it fails
+	// because the network is not ready yet (DNS failures, refused or reset connections), as
+	// when a scheduled run starts right at boot; 0 disables it.
+	StartupWait time.Duration
+
+	// OAuth, when set, authenticates with pre-provisioned OAuth client credentials and
+	// DPoP-bound tokens instead of Handle and Password.
+	OAuth *OAuthConfig
+
+	CAFile             string // PEM file with extra certificate authorities to trust, e.g. for a self-hosted PDS
+	InsecureSkipVerify bool   // Disable TLS certificate verification; for testing only
+	Proxy              string // URL of the proxy for all PDS requests; empty uses HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+
+	// ConnectTimeout bounds establishing connections (dial and TLS handshake) and each
+	// authentication attempt, ReadTimeout each feed page fetch and WriteTimeout each record
+	// write, retries included. 0 keeps the defaults: no per-call bound besides the HTTP
+	// client's 30s per request, which is lifted when ReadTimeout or WriteTimeout is set.
+	ConnectTimeout time.Duration
+	ReadTimeout    time.Duration
+	WriteTimeout   time.Duration
+
+	// Search, when set, amplifies the posts matching this query, by anyone, instead of a
+	// target's feed; at most SearchLimit results (DefaultSearchLimit when 0) are collected.
+	Search      string
+	SearchLimit int
+
+	TargetDID      string        // DID of the account whose feed is amplified
+	TargetHandle   string        // Expected handle of TargetDID; a mismatch is warned about at startup
+	AllowSelf      bool          // Allow TargetDID to be the authenticated account, which is otherwise an error
+	PostURIs       []string      // When set, only these posts are actioned and the feed is not scanned
+	OnlyURIs       []string      // When set, only these posts are actioned, if found in the scanned feed and eligible
+	StarterPack    string        // When set, the members of this starter pack (at://...) are the targets instead of TargetDID
+	Source         string        // SourceAuthor (default) or SourceLikes
+	AuthorFilter   string        // Filter of the author feed, one of the AuthorFilter* values; empty uses the server default
+	Order          string        // OrderOldest (default) or OrderNewest
+	Pick           string        // Name of a PickStrategies entry; defaults to Order
+	Randomize      bool          // Action eligible posts in a random order instead of by Pick; excludes Order and Pick
+	Seed           uint64        // Seed of the Randomize shuffle and the CreatedAtJitter; 0 picks a random seed, which is logged
+	SortBy         string        // SortIndexedAt (default) or SortCreatedAt
+	Count          int           // Maximum number of posts to action; defaults to 1
+	CollectBudget  time.Duration // Maximum time spent paginating the feed; 0 means no limit
+	MaxPages       int           // Maximum number of pages fetched per feed; 0 means no limit
+	PageDelay      time.Duration // Pause between two page fetches; defaults to one second, negative means none
+	ResolvePDS     bool          // Read the target's feed from the PDS listed in their DID document
+	StopOnRepeat   bool          // Stop paginating at the first page holding no post not already seen
+	NoBoundaryStop bool          // Scan past the first post already liked and reposted instead of stopping there
+	IncludeReposts bool          // Also action the original posts the target reposted; only meaningful with SourceAuthor
+	Filters        Filters       // Eligibility criteria applied to every candidate
+	SkipOwn        bool          // Never action posts authored by the authenticated account
+	SkipEngaged    bool          // Never action posts the authenticated account replied to or quoted, among its latest 1000 posts
+	SkipTargetOwn  bool          // Never action posts authored by the target; only meaningful with SourceLikes
+	Explain        bool          // Log, for every candidate, each eligibility check it passed or failed and the decision
+	DumpFeed       string        // When set, the collected feed is written to this JSON file before selection
+	ThreadDedup    bool          // Action at most one eligible post per thread
+	ThreadPick     string        // Post kept by ThreadDedup: ThreadPickRoot (default) or ThreadPickMostEngaged
+
+	// MinFollowers and MinAccountAge, when positive, skip targets (including starter pack
+	// members and weighted targets) with fewer followers or a more recent account.
+	MinFollowers  int64
+	MinAccountAge time.Duration
+
+	// Targets, when set, are the targets instead of TargetDID, and Count is shared among them
+	// in proportion to their weights, across runs, using the counts kept in the state file.
+	Targets []WeightedTarget
+
+	// ResumeScan saves the feed cursor in the state file after every page of a full scan, so
+	// a run interrupted while collecting resumes paging from there instead of from the newest
+	// post. Saved cursors older than ResumeStaleness (when positive) are discarded, since
+	// cursors can expire. Streaming (newest-first) runs do not use it.
+	ResumeScan      bool
+	ResumeStaleness time.Duration
+
+	DryRun            bool // Log the actions instead of performing them
+	Diff              bool // In dry-run mode, only report eligible posts not reported by a previous Diff run nor actioned, tracked in the state file
+	ParallelActions   bool // Issue the like and repost of a post concurrently
+	StopOnActionError bool // Abort the remaining actions after the first failed like or repost
+	FailureThreshold  int  // Abort with ErrCircuitOpen after this many consecutive posts with a failed action; 0 disables it
+
+	WriteInterval time.Duration // Minimum delay between the start of two writes; 0 means no spacing
+
+	// RepostRequiresPriorLike spreads amplification over two runs: a post is liked on the
+	// first run that selects it and only reposted by a later run, once the like is visible
+	// in the viewer state.
+	RepostRequiresPriorLike bool
+
+	// AckReplies only likes, never reposts, posts replying to a thread or post authored by
+	// the authenticated account, acknowledging the reply without amplifying it.
+	AckReplies bool
+
+	// ActionRules, when set, restricts the actions taken on each post by its category, e.g. to
+	// repost original posts only and just like replies and quotes.
+	ActionRules ActionRules
+
+	// OnEvent, when set, is called synchronously with each step of the run as it happens;
+	// see the Event* constants for the event types and their fields.
+	OnEvent func(Event)
+
+	// Confirm, when set, is called in live runs with the posts about to be actioned;
+	// nothing is written unless it returns true.
+	Confirm func(plan []*bsky.FeedDefs_PostView) bool
+
+	CurateCollection string // NSID of a collection in which a record referencing each reposted post is also created
+
+	ViaFeed string // When set, the feed generator (at://...) credited in the via field of repost records
+
+	// Labeler, when set, is the DID of a labeler queried before actioning each post: posts that it,
+	// or whose author it, labeled with one of BlockLabels are skipped. Results are cached per run,
+	// and a post is also skipped when the labeler cannot be queried.
+	Labeler     string
+	BlockLabels []string
+
+	SandboxRepo string // When set, like and repost records are written to this repo DID instead of the authenticated account
+
+	// CreatedAtJitter, when positive, backdates the createdAt of each like and repost record by a
+	// random amount up to it, at most MaxCreatedAtJitter, so records are not perfectly aligned.
+	CreatedAtJitter time.Duration
+
+	StateFile       string // Path of the JSON file persisting state between runs; empty disables it
+	StartFromLatest bool   // On the first run record the newest post as a boundary and only action newer posts afterwards
+	DailyCap        int    // Maximum likes plus reposts in any rolling 24 hours, tracked in the state file; 0 means no cap
+
+	// StateRetention, when positive, prunes the action records older than this from the state
+	// file every time it is saved. It must cover the daily cap, catch-up and cooldown windows.
+	StateRetention time.Duration
+
+	// FirstRunMarker caps the first live run against a target, detected by its absence from the
+	// state file, to FirstRunCap posts (at least 1) whatever Count, so that a newly configured large
+	// Count does not flood on day one; later runs use the full Count.
+	FirstRunMarker bool
+	FirstRunCap    int
+
+	PinActioned bool // After the run, pin the last post it reposted on the profile
+
+	PostSummary     bool          // Post a summary of the posts amplified in the last 24 hours after a run that actioned something
+	SummaryInterval time.Duration // Minimum time between two summary posts, tracked in the state file
+
+	// CatchupRate, when N is positive, limits the posts actioned in any period of CatchupRate.Per
+	// to CatchupRate.N, tracked in the state file, and spaces the posts of a run evenly over it,
+	// so a large backlog is worked through gradually by successive runs.
+	CatchupRate Rate
+
+	// ActiveHours, when set, restricts runs to a daily window: outside it a run returns
+	// without authenticating or acting.
+	ActiveHours *ActiveHours
+
+	// AuthorCooldown allows at most one repost per author in any window of this length,
+	// across runs when StateFile is set; 0 disables it.
+	AuthorCooldown time.Duration
+
+	// SelfLabels are self-label values, among SelfLabelValues, attached to the quote posts.
+	SelfLabels []string
+
+	// QuoteText, when set, replaces the repost of each post with a quote post with this text,
+	// except for the posts of weighted targets with their own actions. QuoteFrom, when set,
+	// takes the text of each quote post from the quoted post, falling back to the quote text:
+	// QuoteFromExternalTitle uses the title of its link card.
+	QuoteText string
+	QuoteFrom string
+}
+
+// quotes reports whether posts are quoted, with QuoteText or by a target quoting posts.
+func (cfg Config) quotes() bool {
+	return cfg.QuoteText != "" || slices.ContainsFunc(cfg.Targets, func(t WeightedTarget) bool { return t.QuoteText != "" })
+}
+
+// Result holds the outcome of a run.
+type Result struct {
+	Liked        int            // Number of likes performed (or that would have been, in dry-run mode)
+	Reposted     int            // Number of reposts performed (or that would have been, in dry-run mode)
+	Skipped      int            // Number of collected posts that were not eligible
+	ActionedURIs []string       // URIs of the posts that were actioned, in order
+	Actions      []ActionedPost // Every post liked or reposted (or that would have been), in order, even if one of its actions failed
+	FailedURIs   []string       // URIs of the posts for which a like or repost failed, in order
+
+	PagesFetched int   // Number of feed pages fetched successfully
+	ScanErr      error // Fetch error that cut the feed scan short; eligible posts may have been missed
+
+	APICalls map[string]int // Number of XRPC calls made, by method NSID
+	Errors   []RunError     // Non-fatal errors: failed actions, then the feed scan error if any
+
+	// In dry-run mode, the writes the run would have performed and the minimum time
+	// the write limiter would have needed to perform them.
+	EstimatedWrites   int
+	EstimatedDuration time.Duration
+
+	// Funnel counts the posts left at each stage of the selection.
+	Funnel Funnel
+}
+
+// Pretty renders the result as a single human-friendly line.
+func (r Result) Pretty(dryRun bool) string {
+	if dryRun {
+		return fmt.Sprintf("❤️ would like %d · 🔁 would repost %d · ⏭ skipped %d · ✍️ %d writes in ≥%s",
+			r.Liked, r.Reposted, r.Skipped, r.EstimatedWrites, r.EstimatedDuration)
+	}
+	return fmt.Sprintf("❤️ liked %d · 🔁 reposted %d · ⏭ skipped %d", r.Liked, r.Reposted, r.Skipped)
+}
+
+// withDefaults returns a copy of cfg with unset options replaced by their defaults.
+func (cfg Config) withDefaults() Config {
+	if cfg.Source == "" {
+		cfg.Source = SourceAuthor
+	}
+	if cfg.Order == "" {
+		cfg.Order = OrderOldest
+	}
+	if cfg.Pick == "" {
+		cfg.Pick = cfg.Order
+	}
+	if cfg.SortBy == "" {
+		cfg.SortBy = SortIndexedAt
+	}
+	if cfg.ThreadPick == "" {
+		cfg.ThreadPick = ThreadPickRoot
+	}
+	if cfg.Count == 0 {
+		cfg.Count = 1
+	}
+	if cfg.PageDelay == 0 {
+		cfg.PageDelay = time.Second
+	}
+	if cfg.SearchLimit == 0 {
+		cfg.SearchLimit = DefaultSearchLimit
+	}
+	if cfg.AuthAttempts < 1 {
+		cfg.AuthAttempts = 1
+	}
+	if cfg.RetryableErrors == nil {
+		cfg.RetryableErrors = DefaultRetryableErrors
+	}
+	return cfg
+}
+
+// Validate checks that the configuration is complete and consistent.
+func (cfg Config) Validate() error {
+	if cfg.Randomize && (cfg.Order != "" || cfg.Pick != "") {
+		return fmt.Errorf("randomize cannot be combined with an order or pick strategy")
+	}
+	cfg = cfg.withDefaults()
+	if cfg.OAuth != nil {
+		if err := cfg.OAuth.Validate(); err != nil {
+			return err
+		}
+	} else {
+		if cfg.Handle == "" {
+			return fmt.Errorf("handle is required")
+		}
+		if cfg.Password == "" {
+			return fmt.Errorf("password is required")
+		}
+	}
+	if cfg.TargetDID == "" && len(cfg.PostURIs) == 0 && cfg.StarterPack == "" && len(cfg.Targets) == 0 && cfg.Search == "" {
+		return fmt.Errorf("target DID is required")
+	}
+	if cfg.Search != "" {
+		if cfg.TargetDID != "" || len(cfg.PostURIs) > 0 || cfg.StarterPack != "" || len(cfg.Targets) > 0 {
+			return fmt.Errorf("a search cannot be combined with a target DID, post URIs, a starter pack or weighted targets")
+		}
+		if cfg.Source == SourceLikes || cfg.AuthorFilter != "" || cfg.IncludeReposts || cfg.StartFromLatest || cfg.ResolvePDS || cfg.ResumeScan || cfg.MinFollowers > 0 || cfg.MinAccountAge > 0 {
+			return fmt.Errorf("a search cannot be combined with options of target feeds: the likes source, an author feed filter, including reposts, start from latest, PDS resolution, resuming scans or target follower and age minimums")
+		}
+		if cfg.SearchLimit < 0 {
+			return fmt.Errorf("invalid search limit %d, must not be negative", cfg.SearchLimit)
+		}
+	}
+	if len(cfg.Targets) > 0 {
+		if cfg.StateFile == "" {
+			return fmt.Errorf("weighted targets require a state file")
+		}
+		if cfg.TargetDID != "" || len(cfg.PostURIs) > 0 || cfg.StarterPack != "" || cfg.StartFromLatest || cfg.ResolvePDS || cfg.SkipTargetOwn || cfg.ResumeScan {
+			return fmt.Errorf("weighted targets cannot be combined with a target DID, post URIs, a starter pack, start from latest, PDS resolution, skipping the target's own posts or resuming scans")
+		}
+		seen := make(map[string]bool, len(cfg.Targets))
+		for _, t := range cfg.Targets {
+			if err := t.validate(); err != nil {
+				return err
+			}
+			if seen[t.DID] {
+				return fmt.Errorf("duplicate weighted target %s", t.DID)
+			}
+			seen[t.DID] = true
+		}
+	}
+	if cfg.StarterPack != "" {
+		if !strings.HasPrefix(cfg.StarterPack, "at://") {
+			return fmt.Errorf("invalid starter pack %q, expected at://...", cfg.StarterPack)
+		}
+		if cfg.TargetDID != "" || len(cfg.PostURIs) > 0 || cfg.StartFromLatest || cfg.ResolvePDS || cfg.SkipTargetOwn {
+			return fmt.Errorf("a starter pack cannot be combined with a target DID, post URIs, start from latest, PDS resolution or skipping the target's own posts")
+		}
+	}
+	for _, uri := range cfg.PostURIs {
+		if !strings.HasPrefix(uri, "at://") {
+			return fmt.Errorf("invalid post URI %q, expected at://...", uri)
+		}
+	}
+	for _, uri := range cfg.OnlyURIs {
+		if !strings.HasPrefix(uri, "at://") {
+			return fmt.Errorf("invalid allowlisted post URI %q, expected at://...", uri)
+		}
+	}
+	if len(cfg.OnlyURIs) > 0 && len(cfg.PostURIs) > 0 {
+		return fmt.Errorf("an allowlist cannot be combined with post URIs, which are actioned without scanning the feed")
+	}
+	if cfg.AuthorFilter != "" {
+		if cfg.Source != SourceAuthor {
+			return fmt.Errorf("an author feed filter requires the %s source", SourceAuthor)
+		}
+		if !slices.Contains(authorFilters, cfg.AuthorFilter) {
+			return fmt.Errorf("invalid author feed filter %q, expected one of %v", cfg.AuthorFilter, authorFilters)
+		}
+	}
+	if cfg.IncludeReposts && cfg.Source != SourceAuthor {
+		return fmt.Errorf("including reposts requires the %s source", SourceAuthor)
+	}
+	if cfg.SkipTargetOwn && cfg.Source != SourceLikes {
+		return fmt.Errorf("skipping the target's own posts requires the %s source", SourceLikes)
+	}
+	if len(cfg.PostURIs) > 0 && (cfg.StartFromLatest || cfg.ResolvePDS || cfg.DumpFeed != "") {
+		return fmt.Errorf("post URIs cannot be combined with start from latest, PDS resolution or dumping the feed")
+	}
+	if cfg.Order != OrderOldest && cfg.Order != OrderNewest {
+		return fmt.Errorf("invalid order %q, expected %s or %s", cfg.Order, OrderOldest, OrderNewest)
+	}
+	if _, ok := PickStrategies[cfg.Pick]; !ok {
+		return fmt.Errorf("invalid pick strategy %q, expected one of %v", cfg.Pick, slices.Sorted(maps.Keys(PickStrategies)))
+	}
+	if cfg.SortBy != SortIndexedAt && cfg.SortBy != SortCreatedAt {
+		return fmt.Errorf("invalid sort %q, expected %s or %s", cfg.SortBy, SortIndexedAt, SortCreatedAt)
+	}
+	if cfg.ThreadPick != ThreadPickRoot && cfg.ThreadPick != ThreadPickMostEngaged {
+		return fmt.Errorf("invalid thread pick %q, expected %s or %s", cfg.ThreadPick, ThreadPickRoot, ThreadPickMostEngaged)
+	}
+	if cfg.Source != SourceAuthor && cfg.Source != SourceLikes {
+		return fmt.Errorf("invalid source %q, expected %s or %s", cfg.Source, SourceAuthor, SourceLikes)
+	}
+	if cfg.ConnectTimeout < 0 || cfg.ReadTimeout < 0 || cfg.WriteTimeout < 0 {
+		return fmt.Errorf("invalid timeouts (connect %s, read %s, write %s), must not be negative", cfg.ConnectTimeout, cfg.ReadTimeout, cfg.WriteTimeout)
+	}
+	if cfg.Proxy != "" {
+		if _, err := ParseProxyURL(cfg.Proxy); err != nil {
+			return err
+		}
+	}
+	if cfg.WriteInterval < 0 {
+		return fmt.Errorf("invalid write interval %s, must not be negative", cfg.WriteInterval)
+	}
+	if cfg.Filters.MaxPostAge < 0 {
+		return fmt.Errorf("invalid max post age %s, must not be negative", cfg.Filters.MaxPostAge)
+	}
+	if cfg.SessionKey != "" && cfg.SessionFile == "" {
+		return fmt.Errorf("a session key requires a session file")
+	}
+	if cfg.SessionFile != "" && cfg.OAuth != nil {
+		return fmt.Errorf("a session file cannot be combined with OAuth, which keeps its tokens in the OAuth token file")
+	}
+	if cfg.StartupWait < 0 {
+		return fmt.Errorf("invalid startup wait %s, must not be negative", cfg.StartupWait)
+	}
+	if cfg.MinFollowers < 0 || cfg.MinAccountAge < 0 {
+		return fmt.Errorf("invalid target minimums %d followers and %s account age, must not be negative", cfg.MinFollowers, cfg.MinAccountAge)
+	}
+	if cfg.MaxPages < 0 {
+		return fmt.Errorf("invalid max pages %d, must not be negative", cfg.MaxPages)
+	}
+	if cfg.FailureThreshold < 0 {
+		return fmt.Errorf("invalid failure threshold %d, must not be negative", cfg.FailureThreshold)
+	}
+	if cfg.Count < 1 {
+		return fmt.Errorf("invalid count %d, must be at least 1", cfg.Count)
+	}
+	if cfg.SandboxRepo != "" && !strings.HasPrefix(cfg.SandboxRepo, "did:") {
+		return fmt.Errorf("invalid sandbox repo %q, expected a DID", cfg.SandboxRepo)
+	}
+	if cfg.CurateCollection != "" && len(strings.Split(cfg.CurateCollection, ".")) < 3 {
+		return fmt.Errorf("invalid curate collection %q, expected an NSID such as com.example.curated.item", cfg.CurateCollection)
+	}
+	if cfg.ViaFeed != "" && (!strings.HasPrefix(cfg.ViaFeed, "at://") || !strings.Contains(cfg.ViaFeed, "/app.bsky.feed.generator/")) {
+		return fmt.Errorf("invalid via feed %q, expected at://.../app.bsky.feed.generator/...", cfg.ViaFeed)
+	}
+	if cfg.Labeler != "" && !strings.HasPrefix(cfg.Labeler, "did:") {
+		return fmt.Errorf("invalid labeler %q, expected a DID", cfg.Labeler)
+	}
+	if (cfg.Labeler == "") != (len(cfg.BlockLabels) == 0) {
+		return fmt.Errorf("labeler and block labels must be set together")
+	}
+	if cfg.CreatedAtJitter < 0 || cfg.CreatedAtJitter > MaxCreatedAtJitter {
+		return fmt.Errorf("invalid created-at jitter %s, must be between 0 and %s", cfg.CreatedAtJitter, MaxCreatedAtJitter)
+	}
+	for _, v := range cfg.SelfLabels {
+		if !slices.Contains(SelfLabelValues, v) {
+			return fmt.Errorf("invalid self-label %q, expected one of %s", v, strings.Join(SelfLabelValues, ", "))
+		}
+	}
+	if len(cfg.SelfLabels) > 0 && !cfg.quotes() {
+		return fmt.Errorf("self-labels require a quote text or a target quoting posts")
+	}
+	if cfg.QuoteFrom != "" && cfg.QuoteFrom != QuoteFromExternalTitle {
+		return fmt.Errorf("invalid quote source %q, expected %s", cfg.QuoteFrom, QuoteFromExternalTitle)
+	}
+	if cfg.QuoteFrom != "" && !cfg.quotes() {
+		return fmt.Errorf("quote source requires a quote text or a target quoting posts")
+	}
+	if cfg.StartFromLatest && cfg.StateFile == "" {
+		return fmt.Errorf("start from latest requires a state file")
+	}
+	if cfg.RefreshMargin < 0 {
+		return fmt.Errorf("invalid refresh margin %s, must not be negative", cfg.RefreshMargin)
+	}
+	if cfg.DailyCap < 0 {
+		return fmt.Errorf("invalid daily cap %d, must not be negative", cfg.DailyCap)
+	}
+	if cfg.FirstRunMarker && cfg.StateFile == "" {
+		return fmt.Errorf("first run marker requires a state file")
+	}
+	if cfg.FirstRunCap < 0 {
+		return fmt.Errorf("invalid first run cap %d, must not be negative", cfg.FirstRunCap)
+	}
+	if cfg.FirstRunMarker && cfg.FirstRunCap == 0 {
+		return fmt.Errorf("invalid first run cap 0, must be at least 1 with a first run marker")
+	}
+	if cfg.AuthorCooldown < 0 {
+		return fmt.Errorf("invalid author cooldown %s, must not be negative", cfg.AuthorCooldown)
+	}
+	if cfg.StateRetention < 0 {
+		return fmt.Errorf("invalid state retention %s, must not be negative", cfg.StateRetention)
+	}
+	if cfg.StateRetention > 0 {
+		if window := max(24*time.Hour, cfg.CatchupRate.Per, cfg.AuthorCooldown); cfg.StateRetention < window {
+			return fmt.Errorf("invalid state retention %s, must be at least %s to keep the actions counted by the daily cap, catch-up rate and author cooldown", cfg.StateRetention, window)
+		}
+	}
+	if cfg.PostSummary && cfg.StateFile == "" {
+		return fmt.Errorf("posting a summary requires a state file")
+	}
+	if cfg.CatchupRate.N > 0 && cfg.StateFile == "" {
+		return fmt.Errorf("catch-up rate requires a state file")
+	}
+	if cfg.DailyCap > 0 && cfg.StateFile == "" {
+		return fmt.Errorf("daily cap requires a state file")
+	}
+	if cfg.Diff && (!cfg.DryRun || cfg.StateFile == "") {
+		return fmt.Errorf("diff requires dry-run mode and a state file")
+	}
+	if cfg.ResumeScan && (cfg.StateFile == "" || cfg.StarterPack != "" || len(cfg.PostURIs) > 0) {
+		return fmt.Errorf("resuming scans requires a state file and cannot be combined with a starter pack or post URIs")
+	}
+	if cfg.ResumeStaleness < 0 {
+		return fmt.Errorf("invalid resume staleness %s, must not be negative", cfg.ResumeStaleness)
+	}
+	return nil
+}
+
+// isSelfTarget reports whether target is the authenticated account did.
+func isSelfTarget(did, target string) bool {
+	return target != "" && did == target
+}
+
+// checkTargetHandle warns when the current handle of did differs from the expected one,
+// e.g. because the target changed handle or, worse, the configured DID is not who it used to be.
+// Operations keep using the DID either way.
+func checkTargetHandle(ctx context.Context, xrpcc *xrpc.Client, did, expected string) {
+	profile, err := bsky.ActorGetProfile(ctx, xrpcc, did)
+	if err != nil {
+		slog.Warn("Could not check the target's current handle", append([]any{"targetUserDID", did}, ErrorAttrs(err)...)...)
+		return
+	}
+	if !strings.EqualFold(strings.TrimPrefix(expected, "@"), profile.Handle) {
+		slog.Warn("TARGET HANDLE MISMATCH: the target DID now has a different handle than configured; check that it is still the intended account",
+			"targetUserDID", did,
+			"configuredHandle", expected,
+			"currentHandle", profile.Handle,
+		)
+	}
+}
+
+// selectPosts sorts posts by cfg.SortBy, keeps the eligible ones and orders them for
+// actioning by cfg.Pick or, with cfg.Randomize, at random; limit only bounds what is logged.
+// Ineligible posts are counted in *skipped, and every post in the filter stages of funnel.
+func selectPosts(cfg Config, posts []*bsky.FeedDefs_PostView, limit int, skipped *int, funnel *Funnel) []*bsky.FeedDefs_PostView {
+	SortPosts(posts, cfg.SortBy)
+	slog.Info("Posts reordered from oldest to newest.", "sortBy", cfg.SortBy)
+
+	eligible := slices.Collect(eligiblePosts(slices.Values(posts), cfg.Filters, skipped, funnel, cfg.Explain))
+	if cfg.ThreadDedup {
+		deduped := DedupThreads(eligible, cfg.ThreadPick)
+		*skipped += len(eligible) - len(deduped)
+		eligible = deduped
+	}
+	var picked []*bsky.FeedDefs_PostView
+	if cfg.Randomize {
+		seed := cfg.Seed
+		if seed == 0 {
+			seed = rand.Uint64()
+		}
+		picked = Shuffle(eligible, seed)
+		var selected []string
+		for _, post := range picked[:min(limit, len(picked))] {
+			selected = append(selected, post.Uri)
+		}
+		slog.Info("Randomized eligible posts", "seed", seed, "selectedUris", selected)
+	} else {
+		picked = PickStrategies[cfg.Pick](eligible)
+	}
+	if cfg.Explain {
+		for _, post := range eligible {
+			if !slices.Contains(picked, post) {
+				slog.Info("Explain post selection", "postUri", post.Uri, "decision", "not picked", "pick", cfg.Pick)
+			}
+		}
+	}
+	return picked
+}
+
+// resumeScan sets up feedOpts to start from the cursor of an interrupted scan saved in state,
+// if it is still fresh, and to save the scan's progress to the state file after every page.
+// When resuming, it returns the URIs of the posts the interrupted scan had collected.
+func resumeScan(cfg Config, state *State, feedOpts *FeedOptions) (collected []string) {
+	now := time.Now()
+	if cursor := state.ResumableCursor(cfg.TargetDID, cfg.Source, cfg.ResumeStaleness, now); cursor != "" {
+		slog.Info("Resuming interrupted feed scan from saved cursor",
+			"cursor", cursor,
+			"savedAt", state.ScanCursor.SavedAt,
+			"postsCollected", len(state.ScanCursor.Collected),
+		)
+		feedOpts.StartCursor = cursor
+		collected = slices.Clone(state.ScanCursor.Collected)
+	} else if state.ScanCursor != nil {
+		slog.Info("Discarding saved feed cursor, it is stale or belongs to another feed",
+			"savedAt", state.ScanCursor.SavedAt,
+			"staleness", cfg.ResumeStaleness,
+		)
+		state.ScanCursor = nil
+	}
+	feedOpts.OnPage = func(next string, yielded []*bsky.FeedDefs_PostView) {
+		if next == "" {
+			state.ScanCursor = nil
+		} else {
+			if state.ScanCursor == nil {
+				state.ScanCursor = &ScanCursor{Target: cfg.TargetDID, Source: cfg.Source}
+			}
+			for _, post := range yielded {
+				if needsAction(post) {
+					state.ScanCursor.Collected = append(state.ScanCursor.Collected, post.Uri)
+				}
+			}
+			state.ScanCursor.Cursor, state.ScanCursor.SavedAt = next, time.Now().UTC()
+		}
+		// Saved right away: the point is to survive a process that is killed mid-scan.
+		if err := state.Save(cfg.StateFile); err != nil {
+			slog.Warn("Failed to save feed scan progress", "error", err)
+		}
+	}
+	return collected
+}
+
+// ActionedPost describes a post liked and/or reposted by a run. Its exported fields are
+// the ones available to the --action-template of the command.
+type ActionedPost struct {
+	Action       string    // "like", "repost" or "like+repost"
+	URI          string    // at:// URI of the post
+	CID          string    // CID of the post
+	AuthorDID    string    // DID of the post's author
+	AuthorHandle string    // Handle of the post's author
+	Text         string    // Text of the post
+	DryRun       bool      // The actions were only logged
+	At           time.Time // When the actions completed
+
+	Target    string // DID of the target the post was collected for; empty for searches and starter packs
+	LikeURI   string // at:// URI of the like record; empty when not liked or in dry-run mode
+	RepostURI string // at:// URI of the repost record, or of the quote post replacing it; empty when not reposted or in dry-run mode
+}
+
+// newActionedPost describes the actions performed on post, collected for target.
+func newActionedPost(post *bsky.FeedDefs_PostView, target string, outcome PostOutcome, dryRun bool, at time.Time) ActionedPost {
+	action := "like+repost"
+	switch {
+	case !outcome.Reposted:
+		action = "like"
+	case !outcome.Liked:
+		action = "repost"
+	}
+	a := ActionedPost{
+		Action:    action,
+		URI:       post.Uri,
+		CID:       post.Cid,
+		DryRun:    dryRun,
+		At:        at,
+		Target:    target,
+		LikeURI:   outcome.LikeURI,
+		RepostURI: outcome.RepostURI,
+	}
+	if post.Author != nil {
+		a.AuthorDID, a.AuthorHandle = post.Author.Did, post.Author.Handle
+	}
+	if record := postRecord(post); record != nil {
+		a.Text = record.Text
+	}
+	return a
+}
@@ -0,0 +1,79 @@
+package reposter
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+)
+
+// benchmarkFeed returns n posts of testTarget, newest first as feeds list them.
+func benchmarkFeed(n int) []*bsky.FeedDefs_PostView {
+	posts := make([]*bsky.FeedDefs_PostView, n)
+	for i := range posts {
+		posts[i] = testPost(testTarget, n-i)
+	}
+	return posts
+}
+
+func BenchmarkSelectPosts(b *testing.B) {
+	for _, n := range []int{100, 10000} {
+		for _, pick := range []string{OrderOldest, OrderNewest} {
+			b.Run(fmt.Sprintf("posts=%d/pick=%s", n, pick), func(b *testing.B) {
+				cfg := Config{Pick: pick}.withDefaults()
+				feed := benchmarkFeed(n)
+				var skipped int
+				for b.Loop() {
+					selectPosts(cfg, slices.Clone(feed), 10, &skipped, &Funnel{})
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkEligiblePostsStreaming selects the newest posts as newest-first runs do, stopping
+// after the first eligible ones instead of sorting and filtering the whole feed.
+func BenchmarkEligiblePostsStreaming(b *testing.B) {
+	for _, n := range []int{100, 10000} {
+		b.Run(fmt.Sprintf("posts=%d", n), func(b *testing.B) {
+			feed := benchmarkFeed(n)
+			var skipped int
+			for b.Loop() {
+				picked := 0
+				for range eligiblePosts(slices.Values(feed), Filters{}, &skipped, &Funnel{}, false) {
+					if picked++; picked == 10 {
+						break
+					}
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkRunFeed runs a dry run actioning 10 posts of a 50 page feed served by a fake PDS:
+// newest-first runs stop paginating once done, oldest-first runs read every page.
+func BenchmarkRunFeed(b *testing.B) {
+	const pages, perPage = 50, 10
+	for _, order := range []string{OrderOldest, OrderNewest} {
+		b.Run("order="+order, func(b *testing.B) {
+			pds := newFakePDS(b)
+			feed := benchmarkFeed(pages * perPage)
+			pds.feed(testTarget, chain(slices.Collect(slices.Chunk(feed, perPage))...)...)
+			cfg := testConfig(pds)
+			cfg.DryRun = true
+			cfg.Order = order
+			cfg.Count = 10
+			var fetched int
+			for b.Loop() {
+				result, err := Run(context.Background(), cfg)
+				if err != nil {
+					b.Fatalf("Run returned error: %v", err)
+				}
+				fetched += result.PagesFetched
+			}
+			b.ReportMetric(float64(fetched)/float64(b.N), "pages/op")
+		})
+	}
+}
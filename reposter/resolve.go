@@ -0,0 +1,72 @@
+package reposter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// PLCDirectory is the default directory used to resolve did:plc identifiers.
+const PLCDirectory = "https://plc.directory"
+
+// didDocument is the subset of a DID document needed to locate the account's services.
+type didDocument struct {
+	ID      string `json:"id"`
+	Service []struct {
+		ID              string `json:"id"`
+		Type            string `json:"type"`
+		ServiceEndpoint string `json:"serviceEndpoint"`
+	} `json:"service"`
+}
+
+// resolvePDSForDID resolves did's DID document (via the PLC directory for did:plc, or the
+// well-known document for did:web) and returns its #atproto_pds service endpoint.
+func resolvePDSForDID(ctx context.Context, c *http.Client, did string) (string, error) {
+	return resolveServiceForDID(ctx, c, did, "atproto_pds", "AtprotoPersonalDataServer")
+}
+
+// resolveLabelerForDID resolves did's DID document like resolvePDSForDID and returns its
+// #atproto_labeler service endpoint.
+func resolveLabelerForDID(ctx context.Context, c *http.Client, did string) (string, error) {
+	return resolveServiceForDID(ctx, c, did, "atproto_labeler", "AtprotoLabeler")
+}
+
+// resolveServiceForDID returns the endpoint of the service with the given fragment id and type
+// in did's DID document.
+func resolveServiceForDID(ctx context.Context, c *http.Client, did, id, typ string) (string, error) {
+	var docURL string
+	switch {
+	case strings.HasPrefix(did, "did:plc:"):
+		docURL = PLCDirectory + "/" + did
+	case strings.HasPrefix(did, "did:web:"):
+		docURL = "https://" + strings.TrimPrefix(did, "did:web:") + "/.well-known/did.json"
+	default:
+		return "", fmt.Errorf("unsupported DID method: %s", did)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, docURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch DID document for %s: %w", did, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to fetch DID document for %s: HTTP %d", did, resp.StatusCode)
+	}
+
+	var doc didDocument
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return "", fmt.Errorf("failed to decode DID document for %s: %w", did, err)
+	}
+	for _, svc := range doc.Service {
+		if (svc.ID == "#"+id || svc.ID == did+"#"+id) && svc.Type == typ {
+			return strings.TrimSuffix(svc.ServiceEndpoint, "/"), nil
+		}
+	}
+	return "", fmt.Errorf("DID document for %s has no #%s service", did, id)
+}
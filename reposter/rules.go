@@ -0,0 +1,94 @@
+package reposter
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+)
+
+// Post categories of ActionRules. A post is a reply, a quote or an original post, checked in
+// that order; whichever it is, it is also a media post when it embeds images or video.
+const (
+	CategoryReply    = "reply"    // Posts replying to another post
+	CategoryQuote    = "quote"    // Posts quoting another record, with or without media
+	CategoryMedia    = "media"    // Posts embedding images or video, whatever their other category
+	CategoryOriginal = "original" // Posts neither replying nor quoting, with or without media
+)
+
+var postCategories = []string{CategoryReply, CategoryQuote, CategoryMedia, CategoryOriginal}
+
+// ruleActions is the set of actions an ActionRules entry allows.
+type ruleActions struct {
+	like, repost bool
+}
+
+// ActionRules maps post categories to the actions taken on posts of that category.
+// A post with images or video belongs to the media category as well as to reply, quote or
+// original, and only gets the actions allowed by the rules of both; a post whose categories
+// have no rule is liked and reposted. Rules only narrow the actions other settings allow,
+// never widen them.
+type ActionRules map[string]ruleActions
+
+// ParseActionRules parses rules written as comma-separated category=actions pairs, e.g.
+// "original=like+repost,reply=like,quote=like", where actions is like, repost, like+repost or none.
+func ParseActionRules(s string) (ActionRules, error) {
+	rules := make(ActionRules)
+	for rule := range strings.SplitSeq(s, ",") {
+		category, actions, ok := strings.Cut(strings.TrimSpace(rule), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid action rule %q, expected category=actions such as reply=like", rule)
+		}
+		category = strings.TrimSpace(category)
+		if !slices.Contains(postCategories, category) {
+			return nil, fmt.Errorf("invalid category %q in action rule %q, expected %s", category, rule, strings.Join(postCategories, ", "))
+		}
+		if _, dup := rules[category]; dup {
+			return nil, fmt.Errorf("duplicate action rule for category %q", category)
+		}
+		var allowed ruleActions
+		switch strings.TrimSpace(actions) {
+		case "like":
+			allowed.like = true
+		case "repost":
+			allowed.repost = true
+		case "like+repost", "repost+like":
+			allowed = ruleActions{like: true, repost: true}
+		case "none":
+		default:
+			return nil, fmt.Errorf("invalid actions %q in action rule %q, expected like, repost, like+repost or none", actions, rule)
+		}
+		rules[category] = allowed
+	}
+	return rules, nil
+}
+
+// categoriesOf returns the categories of post: reply, quote or original, then media when it has images or video.
+func categoriesOf(post *bsky.FeedDefs_PostView) []string {
+	record := postRecord(post)
+	categories := []string{CategoryOriginal}
+	switch {
+	case record != nil && record.Reply != nil:
+		categories[0] = CategoryReply
+	case record != nil && quotedURI(record) != "":
+		categories[0] = CategoryQuote
+	}
+	if hasMedia(post) {
+		categories = append(categories, CategoryMedia)
+	}
+	return categories
+}
+
+// options returns opts narrowed to the actions the rules of every category of post allow.
+func (r ActionRules) options(post *bsky.FeedDefs_PostView, opts ActionOptions) ActionOptions {
+	for _, category := range categoriesOf(post) {
+		allowed, ok := r[category]
+		if !ok {
+			continue
+		}
+		opts.NoLike = opts.NoLike || !allowed.like
+		opts.LikeOnly = opts.LikeOnly || !allowed.repost
+	}
+	return opts
+}
@@ -0,0 +1,77 @@
+package reposter
+
+import (
+	"testing"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+)
+
+// withImage embeds an image in post's record.
+func withImage(post *bsky.FeedDefs_PostView) *bsky.FeedDefs_PostView {
+	postRecord(post).Embed = &bsky.FeedPost_Embed{EmbedImages: &bsky.EmbedImages{Images: []*bsky.EmbedImages_Image{{Alt: "image"}}}}
+	return post
+}
+
+// asReply makes post a reply to post 0 of testTarget.
+func asReply(post *bsky.FeedDefs_PostView) *bsky.FeedDefs_PostView {
+	parent := &atproto.RepoStrongRef{Uri: testPost(testTarget, 0).Uri, Cid: "bafypost0"}
+	postRecord(post).Reply = &bsky.FeedPost_ReplyRef{Root: parent, Parent: parent}
+	return post
+}
+
+func TestActionRulesOptions(t *testing.T) {
+	tests := []struct {
+		rules            string
+		name             string
+		post             *bsky.FeedDefs_PostView
+		noLike, likeOnly bool
+	}{
+		{"original=like", "original post", testPost(testTarget, 1), false, true},
+		{"original=like", "original post with images", withImage(testPost(testTarget, 1)), false, true},
+		{"original=like", "reply", asReply(testPost(testTarget, 1)), false, false},
+		{"media=repost", "original post with images", withImage(testPost(testTarget, 1)), true, false},
+		{"media=repost", "original post", testPost(testTarget, 1), false, false},
+		{"original=like+repost,media=like", "original post with images", withImage(testPost(testTarget, 1)), false, true},
+		{"original=like,media=repost", "original post with images", withImage(testPost(testTarget, 1)), true, true},
+		{"reply=like,media=none", "reply with images", withImage(asReply(testPost(testTarget, 1))), true, true},
+		{"reply=like+repost", "reply with images", withImage(asReply(testPost(testTarget, 1))), false, false},
+	}
+	for _, tt := range tests {
+		rules, err := ParseActionRules(tt.rules)
+		if err != nil {
+			t.Fatalf("ParseActionRules(%q) returned error: %v", tt.rules, err)
+		}
+		opts := rules.options(tt.post, ActionOptions{})
+		if opts.NoLike != tt.noLike || opts.LikeOnly != tt.likeOnly {
+			t.Errorf("Rules %q on %s: NoLike %t and LikeOnly %t, want %t and %t", tt.rules, tt.name, opts.NoLike, opts.LikeOnly, tt.noLike, tt.likeOnly)
+		}
+	}
+}
+
+func TestParseActionRules(t *testing.T) {
+	rules, err := ParseActionRules(" original = like+repost , reply=like,quote=none,media=repost+like")
+	if err != nil {
+		t.Fatalf("ParseActionRules returned error: %v", err)
+	}
+	want := ActionRules{
+		CategoryOriginal: {like: true, repost: true},
+		CategoryReply:    {like: true},
+		CategoryQuote:    {},
+		CategoryMedia:    {like: true, repost: true},
+	}
+	if len(rules) != len(want) {
+		t.Errorf("ParseActionRules = %v, want %v", rules, want)
+	}
+	for category, allowed := range want {
+		if got, ok := rules[category]; !ok || got != allowed {
+			t.Errorf("ParseActionRules rule for %s = %+v, want %+v", category, got, allowed)
+		}
+	}
+
+	for _, invalid := range []string{"", "original", "video=like", "reply=like,reply=none", "quote=share"} {
+		if _, err := ParseActionRules(invalid); err == nil {
+			t.Errorf("ParseActionRules(%q) returned no error", invalid)
+		}
+	}
+}
@@ -0,0 +1,890 @@
+package reposter
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"iter"
+	"log/slog"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/xrpc"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// PostAction is a post selected by Plan and the writes it needed when it was selected.
+type PostAction struct {
+	Post   *bsky.FeedDefs_PostView
+	Like   bool // The post was not liked yet
+	Repost bool // The post was not reposted yet and, with RepostRequiresPriorLike, already liked
+}
+
+// runMode tells which entry point a run serves, and so which of its phases it goes through.
+type runMode int
+
+const (
+	modeRun   runMode = iota // Collect, select and action posts
+	modePlan                 // Collect and select posts, without actioning them
+	modeApply                // Action the posts of a plan, without collecting any
+)
+
+// Run authenticates, collects the target's feed and actions up to cfg.Count eligible posts.
+func Run(ctx context.Context, cfg Config) (Result, error) {
+	return execute(ctx, cfg, modeRun, func(ctx context.Context, r *runner) error {
+		candidates, limit, err := r.collect(ctx)
+		if err != nil {
+			return err
+		}
+		if r.cfg.Confirm != nil && !r.cfg.DryRun {
+			// The posts shown are admitted as the actions will be, so that no post is
+			// shown and then skipped, or actioned without being shown.
+			var plan []*bsky.FeedDefs_PostView
+			for _, action := range r.plan(ctx, candidates, limit) {
+				plan = append(plan, action.Post)
+			}
+			if len(plan) > 0 && !r.cfg.Confirm(plan) {
+				slog.Info("Plan not confirmed, nothing was actioned.")
+				return nil
+			}
+			candidates = slices.Values(plan)
+		}
+		if err := r.act(ctx, candidates, limit); err != nil {
+			return err
+		}
+		return r.finalize(ctx)
+	})
+}
+
+// Plan performs the read, filter and selection phases of Run and returns the posts that
+// would be actioned, without writing anything. The plan can be reviewed or edited (e.g.
+// entries removed or reordered) before being passed to Apply.
+// Posts are admitted to the plan by the same checks as the actions of Run, so Apply
+// actions the planned posts unless the state or the posts changed in between.
+func Plan(ctx context.Context, cfg Config) ([]PostAction, error) {
+	var plan []PostAction
+	_, err := execute(ctx, cfg, modePlan, func(ctx context.Context, r *runner) error {
+		candidates, limit, err := r.collect(ctx)
+		if err != nil {
+			return err
+		}
+		plan = r.plan(ctx, candidates, limit)
+		r.result.Funnel.Selected += len(plan)
+		slog.Info("Plan ready, nothing was actioned.", "posts", len(plan))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// Apply authenticates and actions the posts of plan, in order, as Run would have.
+// The posts are fetched again first, so only the writes still needed at that time are
+// performed; cfg.Confirm is not called.
+func Apply(ctx context.Context, cfg Config, plan []PostAction) (Result, error) {
+	return execute(ctx, cfg, modeApply, func(ctx context.Context, r *runner) error {
+		candidates, limit, err := r.fetchPlan(ctx, plan)
+		if err != nil {
+			return err
+		}
+		if err := r.act(ctx, candidates, limit); err != nil {
+			return err
+		}
+		return r.finalize(ctx)
+	})
+}
+
+// runner holds what the phases of a run share: the settings, the authenticated client, the
+// state and the options derived from them, and the result being built.
+type runner struct {
+	cfg    Config
+	mode   runMode
+	result *Result
+	events eventFunc
+
+	clientOpts ClientOptions
+	xrpcc      *xrpc.Client
+	did        string
+
+	state      *State
+	feedOpts   FeedOptions
+	feedStats  FeedStats
+	actionOpts ActionOptions
+	labels     *labelChecker
+	quoted     map[string]bool // Posts quoted by the newest posts of the repo quotes are written to, when needed
+
+	targets    []string                  // Accounts whose feeds are read, unless weighted targets are
+	postTarget map[string]string         // Weighted target each candidate was selected for
+	policies   map[string]WeightedTarget // Weighted targets by DID
+	seen       map[string]bool           // URIs of the posts collected, to report allowlisted posts never found
+	admission  admission                 // What the daily cap and the author cooldown still allow
+	attempted  int                       // Posts handed to ProcessPostActions
+
+	deferred []func(error) error // Run after the phases, in reverse order, like defer statements
+}
+
+// execute validates cfg, sets up a run and hands it to phases, then tears it down: the state
+// is saved and the run's span and completion event are emitted whatever the outcome.
+func execute(ctx context.Context, cfg Config, mode runMode, phases func(context.Context, *runner) error) (result Result, err error) {
+	if err := cfg.Validate(); err != nil {
+		return result, err
+	}
+	cfg = cfg.withDefaults()
+
+	ctx, span := startSpan(ctx, "run",
+		attribute.String("target.did", cfg.TargetDID),
+		attribute.Bool("dry_run", cfg.DryRun),
+		attribute.Bool("plan_only", mode == modePlan),
+	)
+	defer func() {
+		span.SetAttributes(
+			attribute.Int("run.liked", result.Liked),
+			attribute.Int("run.reposted", result.Reposted),
+			attribute.Int("run.skipped", result.Skipped),
+			attribute.Int("run.failed", len(result.FailedURIs)),
+		)
+		endSpan(span, err)
+	}()
+
+	if cfg.ActiveHours != nil {
+		now := time.Now()
+		if !cfg.ActiveHours.Contains(now) {
+			slog.Info("Outside the active hours, exiting without acting.",
+				"activeHours", cfg.ActiveHours.String(),
+				"localTime", now.In(cfg.ActiveHours.Location).Format(time.TimeOnly),
+				"opensIn", cfg.ActiveHours.Until(now).Round(time.Minute),
+			)
+			return result, nil
+		}
+		slog.Info("Inside the active hours", "activeHours", cfg.ActiveHours.String(), "localTime", now.In(cfg.ActiveHours.Location).Format(time.TimeOnly))
+	}
+
+	events := eventFunc(cfg.OnEvent)
+	defer func() {
+		attrs := []any{"liked", result.Liked, "reposted", result.Reposted, "skipped", result.Skipped, "failed", len(result.FailedURIs)}
+		if err != nil {
+			attrs = append(attrs, "error", err.Error())
+		}
+		events.emit(EventRunComplete, attrs...)
+	}()
+
+	counter := &CallCounter{}
+	defer func() {
+		result.APICalls = counter.Counts()
+		slog.Info("XRPC call counts", "calls", result.APICalls)
+	}()
+
+	r := &runner{cfg: cfg, mode: mode, result: &result, events: events}
+	defer func() {
+		for _, f := range slices.Backward(r.deferred) {
+			err = f(err)
+		}
+	}()
+	done, err := r.setup(ctx, counter)
+	if err != nil || done {
+		return result, err
+	}
+	err = phases(ctx, r)
+	return result, err
+}
+
+// onDone registers f to run once the phases are over, before the functions registered earlier.
+// f receives the run's error and returns it, possibly replaced.
+func (r *runner) onDone(f func(error) error) {
+	r.deferred = append(r.deferred, f)
+}
+
+// setup authenticates and prepares everything the later phases need. It returns done when
+// there is nothing to do in this run, e.g. outside the daily cap or on a first start-from-latest run.
+func (r *runner) setup(ctx context.Context, counter *CallCounter) (done bool, err error) {
+	cfg := &r.cfg
+	if r.clientOpts, err = clientOptions(*cfg, counter); err != nil {
+		return false, err
+	}
+	var handle string
+	if r.xrpcc, r.did, handle, err = connect(ctx, *cfg, r.clientOpts); err != nil {
+		return false, err
+	}
+	did := r.did
+	r.events.emit(EventAuthOK, "did", did, "handle", handle)
+	if !cfg.AllowSelf && (isSelfTarget(did, cfg.TargetDID) || slices.ContainsFunc(cfg.Targets, func(t WeightedTarget) bool { return isSelfTarget(did, t.DID) })) {
+		return false, fmt.Errorf("the target %s is the authenticated account, so it would like and repost its own posts; allow self-targeting explicitly if this is intended", did)
+	}
+
+	cfg.Filters.OnlyURIs = uriSet(cfg.OnlyURIs)
+	if cfg.SkipOwn || cfg.SkipTargetOwn {
+		// Copy the map so the caller's Config is never mutated.
+		excluded := maps.Clone(cfg.Filters.ExcludedAuthors)
+		if excluded == nil {
+			excluded = make(map[string]string)
+		}
+		if cfg.SkipOwn {
+			excluded[did] = "authored by you"
+		}
+		if cfg.SkipTargetOwn {
+			excluded[cfg.TargetDID] = "authored by the target"
+		}
+		cfg.Filters.ExcludedAuthors = excluded
+	}
+
+	// Quote posts leave no trace in the viewer state, so quoting posts also needs the
+	// posts already quoted, to quote each post once: those recorded in the state file, and
+	// those quoted by the newest posts of the repo the quotes are written to.
+	quoteRepo := cmp.Or(cfg.SandboxRepo, did)
+	if cfg.SkipEngaged {
+		own, err := engagedPosts(ctx, r.xrpcc, did)
+		if err != nil {
+			return false, err
+		}
+		cfg.Filters.EngagedPosts = own.byPost()
+		slog.Info("Loaded posts you replied to or quoted, they will be skipped", "count", len(cfg.Filters.EngagedPosts))
+		if quoteRepo == did {
+			r.quoted = own.quoted
+		}
+	}
+	if cfg.quotes() && r.quoted == nil {
+		written, err := engagedPosts(ctx, r.xrpcc, quoteRepo)
+		if err != nil {
+			return false, err
+		}
+		r.quoted = written.quoted
+	}
+
+	r.onDone(func(err error) error {
+		r.result.Funnel.Collected += r.feedStats.Items
+		r.result.Funnel.PassedAuthor += r.feedStats.Authored
+		return err
+	})
+	r.feedOpts = FeedOptions{
+		Source:           cfg.Source,
+		Filter:           cfg.AuthorFilter,
+		Budget:           cfg.CollectBudget,
+		MaxPages:         cfg.MaxPages,
+		PageDelay:        cfg.PageDelay,
+		Stats:            &r.feedStats,
+		StopOnRepeatPage: cfg.StopOnRepeat,
+		ScanPastActioned: cfg.NoBoundaryStop,
+		IncludeReposts:   cfg.IncludeReposts,
+		OnEvent:          cfg.OnEvent,
+		ReadTimeout:      cfg.ReadTimeout,
+	}
+	if cfg.ResolvePDS {
+		// Same network settings as the PDS client, but without DPoP proofs.
+		resolveOpts := ClientOptions{TLSConfig: r.clientOpts.TLSConfig, Proxy: r.clientOpts.Proxy}
+		pds, err := resolvePDSForDID(ctx, NewXRPCClient(resolveOpts).Client, cfg.TargetDID)
+		if err != nil {
+			return false, fmt.Errorf("failed to resolve PDS for target: %w", err)
+		}
+		slog.Info("Reading target feed from their own PDS", "targetUserDID", cfg.TargetDID, "pds", pds)
+		readOpts := r.clientOpts
+		readOpts.Host = pds
+		readOpts.DPoPKey = nil
+		r.feedOpts.ReadClient = NewXRPCClient(readOpts)
+	}
+
+	r.state = &State{}
+	if cfg.StateFile != "" {
+		if r.state, _, err = LoadState(cfg.StateFile); err != nil {
+			return false, err
+		}
+		r.onDone(func(err error) error {
+			if cfg.StateRetention > 0 {
+				r.state.pruneLogged(time.Now().Add(-cfg.StateRetention))
+			}
+			if saveErr := r.state.Save(cfg.StateFile); saveErr != nil && err == nil {
+				return saveErr
+			}
+			return err
+		})
+	}
+	state := r.state
+
+	if cfg.FirstRunMarker && len(cfg.PostURIs) == 0 {
+		keys := firstRunKeys(*cfg)
+		if slices.ContainsFunc(keys, func(key string) bool { return state.FirstRuns[key].IsZero() }) {
+			if r.mode != modeApply && cfg.Count > cfg.FirstRunCap {
+				slog.Warn("FIRST RUN AGAINST THIS TARGET. The count is capped for this run; subsequent runs will use the full count.",
+					"count", cfg.Count,
+					"firstRunCap", cfg.FirstRunCap,
+					"targets", keys,
+				)
+				cfg.Count = cfg.FirstRunCap
+			}
+			if !cfg.DryRun {
+				// Registered after the state save, so it runs before it; a failed run is not a first run.
+				r.onDone(func(err error) error {
+					if err == nil {
+						state.recordFirstRun(keys, time.Now())
+					}
+					return err
+				})
+			}
+		}
+	}
+
+	if cfg.StartFromLatest && r.mode != modeApply {
+		if state.Boundary == nil {
+			boundary, err := NewestPostBoundary(ctx, r.xrpcc, cfg.TargetDID, r.feedOpts)
+			if err != nil {
+				return false, fmt.Errorf("failed to determine start boundary: %w", err)
+			}
+			state.Boundary = boundary
+			slog.Info("First run with start-from-latest: recorded boundary, no posts will be actioned this run",
+				"boundaryUri", boundary.URI,
+				"boundaryIndexedAt", boundary.IndexedAt,
+			)
+			return true, nil
+		}
+		boundaryTime, err := ParseTimestamp(state.Boundary.IndexedAt)
+		if err != nil {
+			return false, fmt.Errorf("invalid boundary timestamp %q in state file: %w", state.Boundary.IndexedAt, err)
+		}
+		slog.Info("Only actioning posts newer than the recorded boundary",
+			"boundaryUri", state.Boundary.URI,
+			"boundaryIndexedAt", state.Boundary.IndexedAt,
+		)
+		cfg.Filters.NewerThan = boundaryTime
+	}
+
+	r.actionOpts = ActionOptions{
+		DryRun:   cfg.DryRun,
+		Parallel: cfg.ParallelActions,
+		Repo:     cfg.SandboxRepo,
+		Limiter:  NewWriteLimiter(cfg.WriteInterval),
+
+		CurateCollection: cfg.CurateCollection,
+
+		RepostRequiresPriorLike: cfg.RepostRequiresPriorLike,
+
+		WriteTimeout: cfg.WriteTimeout,
+
+		QuoteText:  cfg.QuoteText,
+		SelfLabels: cfg.SelfLabels,
+	}
+	if cfg.CreatedAtJitter > 0 {
+		seed := cfg.Seed
+		if seed == 0 {
+			seed = rand.Uint64()
+		}
+		r.actionOpts.Jitter = NewJitter(cfg.CreatedAtJitter, seed)
+		slog.Info("Record timestamps will be jittered", "createdAtJitter", cfg.CreatedAtJitter, "seed", seed)
+	}
+	if cfg.ViaFeed != "" {
+		if r.actionOpts.Via, err = FeedGeneratorRef(ctx, r.xrpcc, cfg.ViaFeed); err != nil {
+			return false, err
+		}
+		slog.Info("Reposts will credit the feed generator", "viaFeed", r.actionOpts.Via.Uri, "viaCid", r.actionOpts.Via.Cid)
+	}
+	if cfg.Labeler != "" {
+		if r.labels, err = newLabelChecker(ctx, r.clientOpts, cfg.Labeler, cfg.BlockLabels, cfg.ReadTimeout); err != nil {
+			return false, err
+		}
+		slog.Info("Posts will be checked against the labeler", "labeler", cfg.Labeler, "blockLabels", cfg.BlockLabels)
+	}
+	r.postTarget = make(map[string]string)
+	r.policies = make(map[string]WeightedTarget, len(cfg.Targets))
+	for _, t := range cfg.Targets {
+		r.policies[t.DID] = t
+	}
+	if cfg.SandboxRepo != "" && !cfg.DryRun {
+		slog.Warn("SANDBOX MODE IS ACTIVE. Like and repost records will be written to the sandbox repo, not your account.",
+			"sandboxRepo", cfg.SandboxRepo,
+		)
+	}
+
+	// The last reposts are also updated by this run, dry or not, so the cooldown applies within it.
+	r.admission = admission{remaining: -1, lastRepost: state.LastReposts(), admitted: make(map[string]bool)}
+	if cfg.DailyCap > 0 {
+		r.admission.remaining = max(cfg.DailyCap-state.ActionsSince(time.Now().Add(-24*time.Hour)), 0)
+		slog.Info("Daily action cap", "cap", cfg.DailyCap, "remaining", r.admission.remaining)
+		if r.admission.remaining == 0 {
+			slog.Info("Daily action cap reached, nothing will be actioned until older actions leave the 24h window.")
+			return true, nil
+		}
+	}
+
+	if cfg.CatchupRate.N > 0 {
+		done := len(state.PostsActionedSince(time.Now().Add(-cfg.CatchupRate.Per)))
+		allowed := max(cfg.CatchupRate.N-done, 0)
+		slog.Info("Catch-up rate", "rate", cfg.CatchupRate, "actionedInPeriod", done, "allowed", allowed, "spacing", cfg.CatchupRate.Spacing())
+		if allowed == 0 {
+			slog.Info("Catch-up rate reached, nothing will be actioned until older actions leave the period.")
+			return true, nil
+		}
+		cfg.Count = min(cfg.Count, allowed)
+	}
+
+	if r.mode == modeApply {
+		return false, nil
+	}
+	if cfg.TargetHandle != "" && cfg.TargetDID != "" {
+		checkTargetHandle(ctx, r.xrpcc, cfg.TargetDID, cfg.TargetHandle)
+	}
+
+	r.targets = []string{cfg.TargetDID}
+	if cfg.StarterPack != "" {
+		if r.targets, err = StarterPackMembers(ctx, r.xrpcc, cfg.StarterPack); err != nil {
+			return false, err
+		}
+	}
+	if (cfg.MinFollowers > 0 || cfg.MinAccountAge > 0) && len(cfg.PostURIs) == 0 {
+		if len(cfg.Targets) > 0 {
+			var dids []string
+			for _, t := range cfg.Targets {
+				dids = append(dids, t.DID)
+			}
+			kept := screenTargets(ctx, r.xrpcc, dids, cfg.MinFollowers, cfg.MinAccountAge)
+			cfg.Targets = slices.DeleteFunc(slices.Clone(cfg.Targets), func(t WeightedTarget) bool { return !slices.Contains(kept, t.DID) })
+			if len(cfg.Targets) == 0 {
+				slog.Info("No target meets the follower and account age minimums, nothing to do.")
+				return true, nil
+			}
+		} else {
+			r.targets = screenTargets(ctx, r.xrpcc, r.targets, cfg.MinFollowers, cfg.MinAccountAge)
+			if len(r.targets) == 0 {
+				slog.Info("No target meets the follower and account age minimums, nothing to do.")
+				return true, nil
+			}
+		}
+	}
+
+	r.seen = make(map[string]bool)
+	if cfg.Filters.OnlyURIs != nil {
+		r.onDone(func(err error) error {
+			warnUnseen(cfg.Filters.OnlyURIs, r.seen)
+			return err
+		})
+	}
+	return false, nil
+}
+
+// postOptions returns the action options for post, which differ from actionOpts for replies to us with AckReplies,
+// for the posts of weighted targets with their own policy, for quote posts with QuoteFrom and for the categories
+// of posts with ActionRules.
+func (r *runner) postOptions(post *bsky.FeedDefs_PostView) ActionOptions {
+	opts := r.actionOpts
+	opts.LikeOnly = r.cfg.AckReplies && isReplyTo(post, r.did)
+	if t, ok := r.policies[r.postTarget[post.Uri]]; ok {
+		opts = t.options(opts)
+	}
+	if opts.QuoteText != "" {
+		if r.cfg.QuoteFrom == QuoteFromExternalTitle {
+			opts.QuoteText = cmp.Or(externalTitle(post), opts.QuoteText)
+		}
+		opts.Quoted = r.quoted[post.Uri] || !r.state.Quoted[post.Uri].IsZero()
+	}
+	return r.cfg.ActionRules.options(post, opts)
+}
+
+// sourcePosts streams the posts to choose from: the search results, or the targets' feeds.
+func (r *runner) sourcePosts(ctx context.Context) iter.Seq[*bsky.FeedDefs_PostView] {
+	if r.cfg.Search != "" {
+		slog.Info("Searching posts instead of reading a target feed", "query", r.cfg.Search, "searchLimit", r.cfg.SearchLimit)
+		return markSeen(SearchPosts(ctx, r.xrpcc, r.cfg.Search, r.cfg.SearchLimit, r.feedOpts), r.seen)
+	}
+	return markSeen(TargetsPosts(ctx, r.xrpcc, r.targets, r.feedOpts), r.seen)
+}
+
+// collect reads the posts given by URI, the weighted targets' feeds, the search results or the
+// targets' feeds, and returns the eligible ones in the order they should be actioned, along
+// with how many of them to action.
+func (r *runner) collect(ctx context.Context) (candidates iter.Seq[*bsky.FeedDefs_PostView], limit int, err error) {
+	cfg, result := r.cfg, r.result
+	limit = cfg.Count
+	if len(cfg.PostURIs) > 0 {
+		slog.Info("Actioning posts given by URI, skipping feed collection", "postUris", cfg.PostURIs)
+		posts, err := FetchPostsByURI(ctx, r.xrpcc, cfg.PostURIs)
+		if err != nil {
+			return nil, 0, err
+		}
+		result.Funnel.Collected += len(posts)
+		result.Funnel.PassedAuthor += len(posts)
+		// Only the viewer-state checks and the max post age safety rail apply to explicitly requested posts.
+		candidates = eligiblePosts(slices.Values(posts), Filters{MaxPostAge: cfg.Filters.MaxPostAge}, &result.Skipped, &result.Funnel, cfg.Explain)
+		limit = len(cfg.PostURIs)
+	} else if len(cfg.Targets) > 0 {
+		// Targets with their own count take it; the others share Count by weight.
+		shared := slices.DeleteFunc(slices.Clone(cfg.Targets), func(t WeightedTarget) bool { return t.Count > 0 })
+		alloc := allocateByWeight(shared, r.state.TargetActions, cfg.Count)
+		for _, t := range cfg.Targets {
+			if t.Count > 0 {
+				alloc[t.DID] = t.Count
+			}
+		}
+		slog.Info("Weighted target allocation", "count", cfg.Count, "allocation", alloc, "actionedSoFar", r.state.TargetActions)
+		var all, picked []*bsky.FeedDefs_PostView
+		taken := make(map[string]bool) // URIs of the posts picked
+		for _, t := range cfg.Targets {
+			targetFeedOpts := r.feedOpts
+			if t.Filter != "" {
+				targetFeedOpts.Filter = t.Filter
+			}
+			posts := slices.Collect(markSeen(TargetUserPosts(ctx, r.xrpcc, t.DID, targetFeedOpts), r.seen))
+			all = append(all, posts...)
+			if alloc[t.DID] == 0 {
+				continue
+			}
+			selected := selectPosts(cfg, posts, alloc[t.DID], &result.Skipped, &result.Funnel)
+			// A post in the feeds of several targets, e.g. liked by two of them, goes to the first one picking it.
+			selected = slices.DeleteFunc(selected, func(post *bsky.FeedDefs_PostView) bool { return taken[post.Uri] })
+			for _, post := range selected {
+				r.postTarget[post.Uri] = t.DID
+			}
+			// The target's policy may leave nothing to do on an eligible post, e.g. a liked post of a like-only target.
+			selected = slices.DeleteFunc(selected, func(post *bsky.FeedDefs_PostView) bool {
+				return PendingActions(post, r.postOptions(post)) == 0
+			})
+			if len(selected) < alloc[t.DID] {
+				slog.Info("Weighted target has fewer eligible posts than allocated", "targetUserDID", t.DID, "allocated", alloc[t.DID], "eligible", len(selected))
+			}
+			for _, post := range selected[:min(alloc[t.DID], len(selected))] {
+				picked = append(picked, post)
+				taken[post.Uri] = true
+			}
+		}
+		limit = len(picked)
+		if cfg.DumpFeed != "" {
+			if err := DumpFeed(cfg.DumpFeed, all); err != nil {
+				return nil, 0, err
+			}
+			slog.Info("Collected feed written", "path", cfg.DumpFeed, "posts", len(all))
+		}
+		candidates = slices.Values(picked)
+	} else if cfg.Pick == OrderNewest && cfg.SortBy == SortIndexedAt && cfg.DumpFeed == "" && !cfg.ThreadDedup {
+		// Newest-first runs act while paginating and stop as soon as enough posts are actioned.
+		slog.Info("Streaming posts from target user, newest first...")
+		candidates = eligiblePosts(r.sourcePosts(ctx), cfg.Filters, &result.Skipped, &result.Funnel, cfg.Explain)
+	} else {
+		slog.Info("Fetching all posts from target user to pick eligible posts...", "pick", cfg.Pick)
+		var allTargetUserPosts []*bsky.FeedDefs_PostView
+		if cfg.ResumeScan {
+			if uris := resumeScan(cfg, r.state, &r.feedOpts); len(uris) > 0 {
+				// The pages read before the interruption are not read again, so their posts are fetched by URI.
+				resumed, err := FetchPostsByURI(ctx, r.xrpcc, uris)
+				if err != nil {
+					return nil, 0, err
+				}
+				slog.Info("Fetched the posts collected before the scan was interrupted", "posts", len(resumed))
+				result.Funnel.Collected += len(resumed)
+				result.Funnel.PassedAuthor += len(resumed)
+				allTargetUserPosts = slices.Collect(markSeen(slices.Values(resumed), r.seen))
+			}
+		}
+		allTargetUserPosts = append(allTargetUserPosts, slices.Collect(r.sourcePosts(ctx))...)
+		slog.Info("Finished collecting target user's posts", "totalPostsCollected", len(allTargetUserPosts))
+		if cfg.DumpFeed != "" {
+			if err := DumpFeed(cfg.DumpFeed, allTargetUserPosts); err != nil {
+				return nil, 0, err
+			}
+			slog.Info("Collected feed written", "path", cfg.DumpFeed, "posts", len(allTargetUserPosts))
+		}
+
+		candidates = slices.Values(selectPosts(cfg, allTargetUserPosts, limit, &result.Skipped, &result.Funnel))
+	}
+
+	if cfg.Diff {
+		candidates = r.state.newSinceLastDiff(candidates)
+	}
+	return candidates, limit, nil
+}
+
+// fetchPlan fetches the posts of plan again, to action the writes they still need.
+func (r *runner) fetchPlan(ctx context.Context, plan []PostAction) (candidates iter.Seq[*bsky.FeedDefs_PostView], limit int, err error) {
+	uris := make([]string, 0, len(plan))
+	for _, action := range plan {
+		uris = append(uris, action.Post.Uri)
+	}
+	slog.Info("Applying plan", "postUris", uris)
+	posts, err := FetchPostsByURI(ctx, r.xrpcc, uris)
+	if err != nil {
+		return nil, 0, err
+	}
+	r.result.Funnel.Collected += len(posts)
+	r.result.Funnel.PassedAuthor += len(posts)
+	candidates = eligiblePosts(slices.Values(posts), Filters{MaxPostAge: r.cfg.Filters.MaxPostAge}, &r.result.Skipped, &r.result.Funnel, r.cfg.Explain)
+	if r.cfg.Diff {
+		candidates = r.state.newSinceLastDiff(candidates)
+	}
+	return candidates, len(uris), nil
+}
+
+// act likes and reposts candidates, in order, until limit posts were attempted, recording
+// every action in the result and the state.
+func (r *runner) act(ctx context.Context, candidates iter.Seq[*bsky.FeedDefs_PostView], limit int) error {
+	cfg, result, state := r.cfg, r.result, r.state
+	if !cfg.DryRun {
+		refreshIfExpiring(ctx, cfg, r.xrpcc, cfg.RefreshMargin)
+	}
+	consecutiveFailures := 0
+	for post := range candidates {
+		postOpts, ok, stop := r.admit(ctx, post, &r.admission)
+		if stop {
+			break
+		}
+		if !ok {
+			continue
+		}
+		if r.attempted > 0 && cfg.CatchupRate.N > 0 && !cfg.DryRun {
+			spacing := cfg.CatchupRate.Spacing()
+			slog.Info("Waiting before the next post to spread the catch-up", "delay", spacing)
+			if err := sleepCtx(ctx, spacing); err != nil {
+				return err
+			}
+			refreshIfExpiring(ctx, cfg, r.xrpcc, cfg.RefreshMargin)
+		}
+		r.attempted++
+		result.Funnel.Selected++
+		r.events.emit(EventPostSelected, "uri", post.Uri, "author", post.Author.Did)
+		outcome, err := ProcessPostActions(ctx, r.xrpcc, post, postOpts)
+		liked, reposted := outcome.Liked, outcome.Reposted
+		now := time.Now()
+		if liked {
+			r.events.emit(EventActionPerformed, "uri", post.Uri, "action", "like", "dryRun", cfg.DryRun)
+		}
+		if reposted {
+			r.events.emit(EventActionPerformed, "uri", post.Uri, "action", "repost", "dryRun", cfg.DryRun)
+		}
+		if err != nil {
+			r.events.emit(EventActionFailed, "uri", post.Uri, "error", err.Error())
+		}
+		if liked || reposted {
+			weighted := r.postTarget[post.Uri]
+			result.Actions = append(result.Actions, newActionedPost(post, cmp.Or(weighted, cfg.TargetDID), outcome, cfg.DryRun, now))
+			if weighted != "" && !cfg.DryRun {
+				if state.TargetActions == nil {
+					state.TargetActions = make(map[string]int)
+				}
+				state.TargetActions[weighted]++
+			}
+		}
+		r.admission.record(post, liked, reposted, now)
+		if liked {
+			result.Liked++
+			if !cfg.DryRun {
+				state.RecordAction(post.Uri, post.Author.Did, "like", now)
+			}
+		}
+		if reposted {
+			result.Reposted++
+			if !cfg.DryRun {
+				state.RecordAction(post.Uri, post.Author.Did, "repost", now)
+				if postOpts.QuoteText != "" {
+					state.recordQuote(post.Uri, now)
+				}
+			}
+		}
+		if err != nil {
+			result.FailedURIs = append(result.FailedURIs, post.Uri)
+			result.Errors = append(result.Errors, RunError{Stage: "action", PostURI: post.Uri, Err: err})
+			if cfg.StopOnActionError {
+				slog.Error("Stopping after failed action", "postUri", post.Uri)
+				return fmt.Errorf("action failed for post %s: %w", post.Uri, err)
+			}
+			consecutiveFailures++
+			if cfg.FailureThreshold > 0 && consecutiveFailures >= cfg.FailureThreshold {
+				slog.Error("Circuit opened: too many consecutive failed actions, aborting the remaining actions",
+					append([]any{"consecutiveFailures", consecutiveFailures, "threshold", cfg.FailureThreshold}, ErrorAttrs(err)...)...,
+				)
+				logRunErrors(result.Errors)
+				return fmt.Errorf("%w after %d consecutive failed actions: %w", ErrCircuitOpen, consecutiveFailures, err)
+			}
+		} else {
+			consecutiveFailures = 0
+			result.ActionedURIs = append(result.ActionedURIs, post.Uri)
+		}
+		if r.attempted >= limit {
+			break
+		}
+	}
+	return nil
+}
+
+// admission tracks, within a run, what the daily cap and the author cooldown still allow and
+// which posts were admitted for actioning already.
+type admission struct {
+	remaining  int                  // Writes left under the daily cap; negative means unlimited
+	lastRepost map[string]time.Time // Last repost of each author
+	admitted   map[string]bool      // URIs of the posts admitted
+}
+
+// clone returns a copy of a that can be used up without affecting a, e.g. to plan a run.
+func (a admission) clone() admission {
+	return admission{remaining: a.remaining, lastRepost: maps.Clone(a.lastRepost), admitted: maps.Clone(a.admitted)}
+}
+
+// record accounts for the writes performed, or planned, on an admitted post.
+func (a *admission) record(post *bsky.FeedDefs_PostView, liked, reposted bool, at time.Time) {
+	if liked {
+		a.remaining--
+	}
+	if reposted {
+		a.remaining--
+		a.lastRepost[post.Author.Did] = at
+	}
+}
+
+// admit runs the checks deciding whether a candidate is actioned, the same for planned and
+// actual actions, and returns the options of its actions. Skipped posts are counted and logged;
+// stop tells that no later candidate can be admitted either, because of the daily cap.
+func (r *runner) admit(ctx context.Context, post *bsky.FeedDefs_PostView, a *admission) (opts ActionOptions, ok, stop bool) {
+	cfg, result := r.cfg, r.result
+	// Candidates are deduplicated when collected; this only guards against writing a second
+	// like or repost should a post still come up twice.
+	if a.admitted[post.Uri] {
+		slog.Debug("Skipping post already admitted in this run", "postUri", post.Uri)
+		return opts, false, false
+	}
+	opts = r.postOptions(post)
+	if cfg.AckReplies && isReplyTo(post, r.did) {
+		if PendingActions(post, opts) == 0 {
+			slog.Debug("Skipping reply to you, already acknowledged with a like", "postUri", post.Uri)
+			result.Skipped++
+			return opts, false, false
+		}
+		slog.Info("Post replies to you, acknowledging it with a like only", "postUri", post.Uri)
+	} else if PendingActions(post, opts) == 0 {
+		slog.Debug("Skipping post, its target's policy or the action rules leave nothing to do", "postUri", post.Uri, "target", r.postTarget[post.Uri])
+		result.Skipped++
+		return opts, false, false
+	}
+	if cfg.AuthorCooldown > 0 && !opts.LikeOnly && (post.Viewer == nil || post.Viewer.Repost == nil) {
+		if last, ok := a.lastRepost[post.Author.Did]; ok && time.Since(last) < cfg.AuthorCooldown {
+			slog.Info("Skipping post, author was reposted within the cooldown",
+				"postUri", post.Uri,
+				"authorDid", post.Author.Did,
+				"lastRepost", last,
+				"cooldown", cfg.AuthorCooldown,
+			)
+			result.Skipped++
+			return opts, false, false
+		}
+	}
+	if r.labels != nil {
+		label, subject, err := r.labels.blockedLabel(ctx, post)
+		if err != nil {
+			slog.Warn("Skipping post, the labeler could not be queried", append([]any{"postUri", post.Uri}, ErrorAttrs(err)...)...)
+			result.Skipped++
+			return opts, false, false
+		}
+		if label != "" {
+			slog.Info("Skipping post, labeled with a blocked label",
+				"postUri", post.Uri,
+				"label", label,
+				"labeledSubject", subject,
+				"labeler", cfg.Labeler,
+			)
+			result.Skipped++
+			return opts, false, false
+		}
+	}
+	if a.remaining >= 0 {
+		if pending := PendingActions(post, opts); pending > a.remaining {
+			slog.Info("Daily action cap reached, stopping before exceeding it",
+				"postUri", post.Uri,
+				"pendingActions", pending,
+				"remaining", a.remaining,
+			)
+			return opts, false, true
+		}
+	}
+	a.admitted[post.Uri] = true
+	return opts, true, false
+}
+
+// plan admits candidates as act would, until limit posts are admitted, without writing
+// anything or using up the run's own admission.
+func (r *runner) plan(ctx context.Context, candidates iter.Seq[*bsky.FeedDefs_PostView], limit int) []PostAction {
+	a := r.admission.clone()
+	var plan []PostAction
+	for post := range candidates {
+		opts, ok, stop := r.admit(ctx, post, &a)
+		if stop {
+			break
+		}
+		if !ok {
+			continue
+		}
+		like, repost := pendingWrites(post, opts)
+		plan = append(plan, PostAction{Post: post, Like: like, Repost: repost})
+		a.record(post, like, repost, time.Now())
+		if len(plan) >= limit {
+			break
+		}
+	}
+	return plan
+}
+
+// finalize reports how the scan and the actions went, then posts the summary and pins the
+// last reposted post when configured.
+func (r *runner) finalize(ctx context.Context) error {
+	cfg, result, state := r.cfg, r.result, r.state
+	result.PagesFetched, result.ScanErr = r.feedStats.Pages, r.feedStats.Err
+	if result.ScanErr != nil {
+		result.Errors = append(result.Errors, RunError{Stage: "feed", Err: result.ScanErr})
+	}
+	switch {
+	case result.ScanErr != nil:
+		slog.Warn("Feed scan was incomplete: a fetch error occurred, eligible posts may have been missed.",
+			append([]any{"pagesCollected", result.PagesFetched, "postsActioned", r.attempted}, ErrorAttrs(result.ScanErr)...)...,
+		)
+	case r.attempted == 0:
+		slog.Info("No un-actioned posts found from the target user's collected feed.", "pagesCollected", result.PagesFetched)
+	}
+	if cfg.DryRun {
+		result.EstimatedWrites = result.Liked + result.Reposted
+		if cfg.CurateCollection != "" {
+			result.EstimatedWrites += result.Reposted
+		}
+		result.EstimatedDuration = r.actionOpts.Limiter.Estimate(result.EstimatedWrites)
+		slog.Info("Dry-run write estimate",
+			"likes", result.Liked,
+			"reposts", result.Reposted,
+			"writes", result.EstimatedWrites,
+			"writeInterval", cfg.WriteInterval,
+			"estimatedDuration", result.EstimatedDuration,
+		)
+	}
+	if cfg.PostSummary && result.Liked+result.Reposted > 0 {
+		if since := time.Since(state.LastSummaryAt); since < cfg.SummaryInterval {
+			slog.Info("Not posting a summary, the previous one is too recent", "lastSummaryAt", state.LastSummaryAt, "interval", cfg.SummaryInterval)
+		} else {
+			amplified := state.PostsActionedSince(time.Now().Add(-24 * time.Hour))
+			for _, uri := range result.ActionedURIs {
+				amplified[uri] = true // Dry runs record nothing in the state
+			}
+			text := fmt.Sprintf("Amplified %d posts in the last 24 hours 🔁", len(amplified))
+			if err := PostText(ctx, r.xrpcc, cfg.SandboxRepo, text, cfg.DryRun); err != nil {
+				slog.Error("Failed to post summary", ErrorAttrs(err)...)
+				result.Errors = append(result.Errors, RunError{Stage: "summary", Err: err})
+			} else if !cfg.DryRun {
+				state.LastSummaryAt = time.Now().UTC()
+			}
+		}
+	}
+	if cfg.PinActioned {
+		for _, action := range slices.Backward(result.Actions) {
+			if action.Action == "like" {
+				continue
+			}
+			if !cfg.DryRun {
+				if err := r.actionOpts.Limiter.Wait(ctx); err != nil {
+					return err
+				}
+			}
+			if err := PinPost(ctx, r.xrpcc, cfg.SandboxRepo, action.URI, action.CID, cfg.DryRun); err != nil {
+				slog.Error("Failed to pin the last reposted post", append([]any{"postUri", action.URI}, ErrorAttrs(err)...)...)
+				result.Errors = append(result.Errors, RunError{Stage: "pin", PostURI: action.URI, Err: err})
+			}
+			break
+		}
+	}
+	if len(result.FailedURIs) > 0 {
+		slog.Error("Some actions failed", "failedCount", len(result.FailedURIs), "failedUris", result.FailedURIs)
+	}
+	logRunErrors(result.Errors)
+	return nil
+}
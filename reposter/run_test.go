@@ -0,0 +1,303 @@
+package reposter
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+)
+
+func TestRunActionsOldestPost(t *testing.T) {
+	pds := newFakePDS(t)
+	pds.feed(testTarget, chain(
+		[]*bsky.FeedDefs_PostView{testPost(testTarget, 4), testPost(testTarget, 3)},
+		[]*bsky.FeedDefs_PostView{testPost(testTarget, 2), testPost(testTarget, 1)},
+	)...)
+
+	result, err := Run(context.Background(), testConfig(pds))
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	oldest := testPost(testTarget, 1).Uri
+	if result.Liked != 1 || result.Reposted != 1 {
+		t.Errorf("Run liked %d and reposted %d posts, want 1 and 1", result.Liked, result.Reposted)
+	}
+	if !slices.Equal(result.ActionedURIs, []string{oldest}) {
+		t.Errorf("Run actioned %v, want %v", result.ActionedURIs, []string{oldest})
+	}
+	if result.PagesFetched != 2 {
+		t.Errorf("Run fetched %d pages, want 2", result.PagesFetched)
+	}
+	likes, reposts := actionedSubjects(pds.records())
+	if !slices.Equal(likes, []string{oldest}) || !slices.Equal(reposts, []string{oldest}) {
+		t.Errorf("Run liked %v and reposted %v, want %s for both", likes, reposts, oldest)
+	}
+	for _, c := range pds.records() {
+		if c.Repo != testDID {
+			t.Errorf("Record written to repo %s, want %s", c.Repo, testDID)
+		}
+	}
+}
+
+func TestRunStopsAtActionedPost(t *testing.T) {
+	pds := newFakePDS(t)
+	pds.feed(testTarget, chain(
+		[]*bsky.FeedDefs_PostView{testPost(testTarget, 6), testPost(testTarget, 5)},
+		[]*bsky.FeedDefs_PostView{testPost(testTarget, 4), actioned(testPost(testTarget, 3)), testPost(testTarget, 2)},
+		[]*bsky.FeedDefs_PostView{testPost(testTarget, 1)},
+	)...)
+
+	result, err := Run(context.Background(), testConfig(pds))
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	// Posts older than the first one already liked and reposted were actioned by earlier runs.
+	want := []string{testPost(testTarget, 4).Uri}
+	if !slices.Equal(result.ActionedURIs, want) {
+		t.Errorf("Run actioned %v, want %v", result.ActionedURIs, want)
+	}
+	if !slices.Equal(pds.fetchedCursors(), []string{"", "c1"}) {
+		t.Errorf("Run fetched the pages at cursors %q, want the first two only", pds.fetchedCursors())
+	}
+}
+
+func TestRunDryRunWritesNothing(t *testing.T) {
+	pds := newFakePDS(t)
+	pds.feed(testTarget, chain([]*bsky.FeedDefs_PostView{testPost(testTarget, 2), testPost(testTarget, 1)})...)
+
+	cfg := testConfig(pds)
+	cfg.DryRun = true
+	cfg.Count = 2
+	result, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if result.Liked != 2 || result.Reposted != 2 {
+		t.Errorf("Dry run would have liked %d and reposted %d posts, want 2 and 2", result.Liked, result.Reposted)
+	}
+	if n := pds.callCount("com.atproto.repo.createRecord"); n != 0 {
+		t.Errorf("Dry run created %d records, want none", n)
+	}
+}
+
+func TestPlanThenApply(t *testing.T) {
+	pds := newFakePDS(t)
+	pds.feed(testTarget, chain([]*bsky.FeedDefs_PostView{testPost(testTarget, 3), testPost(testTarget, 2), testPost(testTarget, 1)})...)
+
+	cfg := testConfig(pds)
+	cfg.Count = 2
+	plan, err := Plan(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Plan returned error: %v", err)
+	}
+	var planned []string
+	for _, action := range plan {
+		planned = append(planned, action.Post.Uri)
+		if !action.Like || !action.Repost {
+			t.Errorf("Plan entry %s has like %t and repost %t, want both", action.Post.Uri, action.Like, action.Repost)
+		}
+	}
+	want := []string{testPost(testTarget, 1).Uri, testPost(testTarget, 2).Uri}
+	if !slices.Equal(planned, want) {
+		t.Fatalf("Plan selected %v, want %v", planned, want)
+	}
+	if n := pds.callCount("com.atproto.repo.createRecord"); n != 0 {
+		t.Fatalf("Plan created %d records, want none", n)
+	}
+
+	result, err := Apply(context.Background(), cfg, plan)
+	if err != nil {
+		t.Fatalf("Apply returned error: %v", err)
+	}
+	if !slices.Equal(result.ActionedURIs, want) {
+		t.Errorf("Apply actioned %v, want %v", result.ActionedURIs, want)
+	}
+	if n := pds.callCount("app.bsky.feed.getAuthorFeed"); n != 1 {
+		t.Errorf("Plan and Apply fetched %d feed pages, want 1", n)
+	}
+}
+
+func TestRunRefusesSelfTarget(t *testing.T) {
+	pds := newFakePDS(t)
+	pds.feed(testDID, chain([]*bsky.FeedDefs_PostView{testPost(testDID, 1)})...)
+
+	cfg := testConfig(pds)
+	cfg.TargetDID = testDID
+	if _, err := Run(context.Background(), cfg); err == nil {
+		t.Fatal("Run targeting the authenticated account returned no error")
+	}
+	weighted := testConfig(pds)
+	weighted.TargetDID = ""
+	weighted.Targets = []WeightedTarget{{DID: testTarget, Weight: 1}, {DID: testDID, Weight: 1}}
+	weighted.StateFile = filepath.Join(t.TempDir(), "state.json")
+	if _, err := Run(context.Background(), weighted); err == nil {
+		t.Fatal("Run with the authenticated account among the weighted targets returned no error")
+	}
+	if n := pds.callCount("app.bsky.feed.getAuthorFeed"); n != 0 {
+		t.Errorf("Refused runs fetched %d feed pages, want none", n)
+	}
+	if n := pds.callCount("com.atproto.repo.createRecord"); n != 0 {
+		t.Errorf("Refused runs created %d records, want none", n)
+	}
+
+	cfg.AllowSelf = true
+	result, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run allowed to target the authenticated account returned error: %v", err)
+	}
+	if want := []string{testPost(testDID, 1).Uri}; !slices.Equal(result.ActionedURIs, want) {
+		t.Errorf("Run actioned %v, want %v", result.ActionedURIs, want)
+	}
+}
+
+func TestRunPaginatesPastEmptyPage(t *testing.T) {
+	pds := newFakePDS(t)
+	pds.feed(testTarget, chain(
+		[]*bsky.FeedDefs_PostView{testPost(testTarget, 3)},
+		nil,
+		[]*bsky.FeedDefs_PostView{testPost(testTarget, 2), testPost(testTarget, 1)},
+	)...)
+
+	result, err := Run(context.Background(), testConfig(pds))
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if want := []string{testPost(testTarget, 1).Uri}; !slices.Equal(result.ActionedURIs, want) {
+		t.Errorf("Run actioned %v, want %v", result.ActionedURIs, want)
+	}
+	if result.PagesFetched != 3 {
+		t.Errorf("Run fetched %d pages, want 3", result.PagesFetched)
+	}
+}
+
+func TestRunActionsRepeatedPostOnce(t *testing.T) {
+	pds := newFakePDS(t)
+	first, second := testPost(testTarget, 1), testPost(testTarget, 2)
+	pds.feed(testTarget, chain([]*bsky.FeedDefs_PostView{second, first})...)
+
+	cfg := testConfig(pds)
+	cfg.TargetDID = ""
+	cfg.PostURIs = []string{first.Uri, first.Uri, second.Uri}
+	result, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	want := []string{first.Uri, second.Uri}
+	if !slices.Equal(result.ActionedURIs, want) {
+		t.Errorf("Run actioned %v, want %v", result.ActionedURIs, want)
+	}
+	likes, reposts := actionedSubjects(pds.records())
+	if !slices.Equal(likes, want) || !slices.Equal(reposts, want) {
+		t.Errorf("Run liked %v and reposted %v, want %v for both", likes, reposts, want)
+	}
+}
+
+func TestApplyActionsRepeatedPlanEntryOnce(t *testing.T) {
+	pds := newFakePDS(t)
+	post := testPost(testTarget, 1)
+	pds.feed(testTarget, chain([]*bsky.FeedDefs_PostView{post})...)
+
+	plan := []PostAction{{Post: post, Like: true, Repost: true}, {Post: post, Like: true, Repost: true}}
+	result, err := Apply(context.Background(), testConfig(pds), plan)
+	if err != nil {
+		t.Fatalf("Apply returned error: %v", err)
+	}
+	if result.Liked != 1 || result.Reposted != 1 {
+		t.Errorf("Apply liked %d and reposted %d times, want 1 and 1", result.Liked, result.Reposted)
+	}
+	if n := pds.callCount("com.atproto.repo.createRecord"); n != 2 {
+		t.Errorf("Apply created %d records, want 2", n)
+	}
+}
+
+func TestRunActionsOnePostPerSelfThread(t *testing.T) {
+	pds := newFakePDS(t)
+	selfThreads(pds)
+
+	cfg := testConfig(pds)
+	cfg.AuthorFilter = AuthorFilterAuthorThreads
+	cfg.ThreadDedup = true
+	cfg.Count = 5
+	result, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	// Posts 2 and 3 are collapsed into the thread of post 1.
+	want := []string{testPost(testTarget, 1).Uri, testPost(testTarget, 4).Uri, testPost(testTarget, 5).Uri}
+	if !slices.Equal(result.ActionedURIs, want) {
+		t.Errorf("Run actioned %v, want %v", result.ActionedURIs, want)
+	}
+}
+
+func TestRunQuotesWithExternalTitle(t *testing.T) {
+	pds := newFakePDS(t)
+	linked, plain := withLinkCard(testPost(testTarget, 2), "A link"), testPost(testTarget, 1)
+	pds.feed(testTarget, chain([]*bsky.FeedDefs_PostView{linked, plain})...)
+
+	cfg := testConfig(pds)
+	cfg.Count = 2
+	cfg.QuoteText = "Worth a read"
+	cfg.QuoteFrom = QuoteFromExternalTitle
+	result, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if result.Liked != 2 || result.Reposted != 2 {
+		t.Errorf("Run liked %d and quoted %d posts, want 2 and 2", result.Liked, result.Reposted)
+	}
+	quotes := make(map[string]string)
+	for _, c := range pds.records() {
+		switch c.Collection {
+		case "app.bsky.feed.repost":
+			t.Errorf("Run reposted %s instead of quoting it", c.subject())
+		case "app.bsky.feed.post":
+			var record bsky.FeedPost
+			if err := json.Unmarshal(c.Record, &record); err != nil {
+				t.Fatalf("Quote post record does not decode: %v", err)
+			}
+			quotes[quotedURI(&record)] = record.Text
+		}
+	}
+	want := map[string]string{linked.Uri: "A link", plain.Uri: "Worth a read"}
+	if !maps.Equal(quotes, want) {
+		t.Errorf("Run quoted %v, want %v", quotes, want)
+	}
+}
+
+func TestRunFirstRunCap(t *testing.T) {
+	pds := newFakePDS(t)
+	pds.feed(testTarget, chain([]*bsky.FeedDefs_PostView{testPost(testTarget, 3), testPost(testTarget, 2), testPost(testTarget, 1)})...)
+
+	cfg := testConfig(pds)
+	cfg.Count = 3
+	cfg.StateFile = filepath.Join(t.TempDir(), "state.json")
+	cfg.FirstRunMarker = true
+	if _, err := Run(context.Background(), cfg); err == nil {
+		t.Fatal("Run with a first run cap of 0 returned no error")
+	}
+	if n := pds.callCount("com.atproto.repo.createRecord"); n != 0 {
+		t.Fatalf("Refused run created %d records, want none", n)
+	}
+
+	cfg.FirstRunCap = 2
+	result, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("First run returned error: %v", err)
+	}
+	want := []string{testPost(testTarget, 1).Uri, testPost(testTarget, 2).Uri}
+	if !slices.Equal(result.ActionedURIs, want) {
+		t.Errorf("First run actioned %v, want %v", result.ActionedURIs, want)
+	}
+	result, err = Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Second run returned error: %v", err)
+	}
+	// The fake PDS does not mark actioned posts, so the second run finds all three again.
+	if len(result.ActionedURIs) != cfg.Count {
+		t.Errorf("Second run actioned %d posts, want the full count of %d", len(result.ActionedURIs), cfg.Count)
+	}
+}
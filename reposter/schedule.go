@@ -0,0 +1,89 @@
+package reposter
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ActiveHours is a daily window of wall-clock time, in Location, during which runs may act.
+// A window whose end is before its start crosses midnight, e.g. 22:00-06:00.
+type ActiveHours struct {
+	Start    time.Duration // Offset of the window's opening from midnight
+	End      time.Duration // Offset of the window's closing from midnight, up to 24h
+	Location *time.Location
+}
+
+// ParseActiveHours parses a window written as HH:MM-HH:MM, e.g. "09:00-22:00", in loc
+// (time.Local when nil). The end may be 24:00; equal start and end are rejected.
+func ParseActiveHours(s string, loc *time.Location) (*ActiveHours, error) {
+	from, to, ok := strings.Cut(strings.TrimSpace(s), "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid active hours %q, expected HH:MM-HH:MM such as 09:00-22:00", s)
+	}
+	start, err := parseClock(from)
+	if err != nil || start == 24*time.Hour {
+		return nil, fmt.Errorf("invalid active hours %q, start must be a time of day between 00:00 and 23:59", s)
+	}
+	end, err := parseClock(to)
+	if err != nil {
+		return nil, fmt.Errorf("invalid active hours %q, end must be a time of day between 00:00 and 24:00", s)
+	}
+	if start == end%(24*time.Hour) {
+		return nil, fmt.Errorf("invalid active hours %q, start and end must differ", s)
+	}
+	if loc == nil {
+		loc = time.Local
+	}
+	return &ActiveHours{Start: start, End: end, Location: loc}, nil
+}
+
+// parseClock parses a time of day written as HH:MM into its offset from midnight, accepting 24:00.
+func parseClock(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "24:00" {
+		return 24 * time.Hour, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// String formats the window as HH:MM-HH:MM followed by its location.
+func (h *ActiveHours) String() string {
+	clock := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d/time.Hour), int(d%time.Hour/time.Minute))
+	}
+	return fmt.Sprintf("%s-%s %s", clock(h.Start), clock(h.End), h.Location)
+}
+
+// sinceMidnight returns how long after midnight, in h.Location, t is.
+func (h *ActiveHours) sinceMidnight(t time.Time) time.Duration {
+	t = t.In(h.Location)
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second + time.Duration(t.Nanosecond())
+}
+
+// Contains reports whether t falls inside the window.
+func (h *ActiveHours) Contains(t time.Time) bool {
+	d := h.sinceMidnight(t)
+	if h.Start < h.End {
+		return d >= h.Start && d < h.End
+	}
+	return d >= h.Start || d < h.End
+}
+
+// Until returns how long after t the window next opens, or 0 when t is inside it.
+// Days lengthened or shortened by daylight saving changes are not accounted for.
+func (h *ActiveHours) Until(t time.Time) time.Duration {
+	if h.Contains(t) {
+		return 0
+	}
+	wait := h.Start - h.sinceMidnight(t)
+	if wait < 0 {
+		wait += 24 * time.Hour
+	}
+	return wait
+}
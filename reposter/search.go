@@ -0,0 +1,79 @@
+package reposter
+
+import (
+	"context"
+	"iter"
+	"log/slog"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/xrpc"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// DefaultSearchLimit is the number of search results collected when Config.SearchLimit is 0.
+const DefaultSearchLimit = 100
+
+// maxSearchPage is the largest page app.bsky.feed.searchPosts returns.
+const maxSearchPage = 100
+
+// SearchPosts streams the posts matching query, newest first, by anyone, stopping after limit
+// results. Pages go through collectFeed, so opts.MaxPages, opts.Budget and opts.PageDelay apply:
+// search is rate limited more strictly than feeds, so keep the delay between pages.
+// Each post is yielded at most once, even when pages overlap.
+func SearchPosts(ctx context.Context, xrpcc *xrpc.Client, query string, limit int, opts FeedOptions) iter.Seq[*bsky.FeedDefs_PostView] {
+	return func(yield func(*bsky.FeedDefs_PostView) bool) {
+		collected := 0
+		seen := make(map[string]bool)
+		fetchPage := func(cursor string) ([]*bsky.FeedDefs_FeedViewPost, *string, error) {
+			slog.Info("Searching posts", "query", query, "cursor", cursor)
+			pageCtx, span := startSpan(ctx, "search.page",
+				attribute.String("search.query", query),
+				attribute.String("feed.cursor", cursor),
+			)
+			pageCtx, cancel := withTimeout(pageCtx, opts.ReadTimeout)
+			defer cancel()
+			out, err := bsky.FeedSearchPosts(pageCtx, xrpcc, "", cursor, "", "", int64(min(limit-collected, maxSearchPage)), "", query, "", "latest", nil, "", "")
+			endSpan(span, err)
+			if err != nil {
+				slog.Error("Failed to search posts", append([]any{"query", query}, ErrorAttrs(err)...)...)
+				return nil, nil, err
+			}
+			eventFunc(opts.OnEvent).emit(EventPageFetched, "source", "search", "query", query, "cursor", cursor, "items", len(out.Posts))
+			// Search returns bare post views; wrap them so pagination is shared with the feeds.
+			items := make([]*bsky.FeedDefs_FeedViewPost, 0, len(out.Posts))
+			for _, post := range out.Posts {
+				items = append(items, &bsky.FeedDefs_FeedViewPost{Post: post})
+			}
+			return items, out.Cursor, nil
+		}
+
+		for items, next := range collectFeed(ctx, fetchPage, opts) {
+			for _, item := range items {
+				post := item.Post
+				if opts.Stats != nil {
+					opts.Stats.Items++
+				}
+				if seen[post.Uri] {
+					slog.Debug("Skipping search result, already seen on an earlier page", "postUri", post.Uri)
+					continue
+				}
+				seen[post.Uri] = true
+				collected++
+				if opts.Stats != nil {
+					opts.Stats.Authored++ // Search results have no authorship guard
+				}
+				slog.Info("Processing search result", "postUri", post.Uri, "authorDid", post.Author.Did, "t", post.IndexedAt)
+				if !yield(post) {
+					return
+				}
+				if collected >= limit {
+					slog.Info("Search limit reached, stopping pagination", "searchLimit", limit)
+					return
+				}
+			}
+			if next == "" {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package reposter
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+)
+
+func TestSearchPostsPageEvents(t *testing.T) {
+	pds := newFakePDS(t)
+	pds.searchResults(chain(
+		[]*bsky.FeedDefs_PostView{testPost("did:plc:a", 3), testPost("did:plc:b", 2)},
+		[]*bsky.FeedDefs_PostView{testPost("did:plc:a", 1)},
+	)...)
+
+	var events []Event
+	opts := FeedOptions{OnEvent: func(e Event) { events = append(events, e) }}
+	var got []string
+	for post := range SearchPosts(context.Background(), pds.client(), "golang", 10, opts) {
+		got = append(got, post.Uri)
+	}
+	if want := []string{testPost("did:plc:a", 3).Uri, testPost("did:plc:b", 2).Uri, testPost("did:plc:a", 1).Uri}; !slices.Equal(got, want) {
+		t.Errorf("SearchPosts yielded %v, want %v", got, want)
+	}
+
+	want := []map[string]any{
+		{"source": "search", "query": "golang", "cursor": "", "items": 2},
+		{"source": "search", "query": "golang", "cursor": "c1", "items": 1},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("SearchPosts sent %d events, want %d", len(events), len(want))
+	}
+	for i, e := range events {
+		if e.Type != EventPageFetched || !maps.Equal(e.Fields, want[i]) {
+			t.Errorf("Event %d = %s %v, want %s %v", i, e.Type, e.Fields, EventPageFetched, want[i])
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package reposter
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// cachedSession is the content of Config.SessionFile.
+type cachedSession struct {
+	Did        string `json:"did"`
+	Handle     string `json:"handle"`
+	AccessJwt  string `json:"accessJwt"`
+	RefreshJwt string `json:"refreshJwt"`
+}
+
+// encryptedSession is the content of Config.SessionFile when Config.SessionKey is set.
+type encryptedSession struct {
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"` // AES-256-GCM encryption of a cachedSession
+}
+
+// resumeSession authenticates xrpcc by refreshing the session cached in cfg.SessionFile,
+// reporting false, after logging why, when there is none or it cannot be used, so that
+// the caller falls back to creating a new session.
+func resumeSession(ctx context.Context, cfg Config, xrpcc *xrpc.Client) (did, handle string, ok bool) {
+	cached, err := loadSession(cfg.SessionFile, cfg.SessionKey)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", "", false
+	}
+	if err != nil {
+		slog.Warn("Could not load the cached session, creating a new one", "sessionFile", cfg.SessionFile, "error", err)
+		return "", "", false
+	}
+	if !strings.EqualFold(cfg.Handle, cached.Handle) && cfg.Handle != cached.Did {
+		slog.Warn("Cached session belongs to another account, creating a new one", "sessionFile", cfg.SessionFile, "cachedHandle", cached.Handle)
+		return "", "", false
+	}
+	// refreshSession authenticates with the refresh token in place of the access token.
+	xrpcc.Auth = &xrpc.AuthInfo{AccessJwt: cached.RefreshJwt, Did: cached.Did, Handle: cached.Handle}
+	session, err := atproto.ServerRefreshSession(ctx, xrpcc)
+	if err != nil {
+		xrpcc.Auth = nil
+		slog.Warn("Could not refresh the cached session, creating a new one", append([]any{"sessionFile", cfg.SessionFile}, ErrorAttrs(err)...)...)
+		return "", "", false
+	}
+	xrpcc.Auth = &xrpc.AuthInfo{AccessJwt: session.AccessJwt, RefreshJwt: session.RefreshJwt, Did: session.Did, Handle: session.Handle}
+	slog.Info("Resumed cached session", "sessionFile", cfg.SessionFile)
+	return session.Did, session.Handle, true
+}
+
+// accessTokenExpiry returns the expiry time in the exp claim of jwt, decoded without
+// verifying the token: it only decides when to refresh, never whether to trust it.
+func accessTokenExpiry(jwt string) (time.Time, error) {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return time.Time{}, fmt.Errorf("malformed JWT, expected 3 parts, got %d", len(parts))
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to decode JWT payload: %w", err)
+	}
+	var claims struct {
+		Exp *int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse JWT claims: %w", err)
+	}
+	if claims.Exp == nil {
+		return time.Time{}, errors.New("JWT has no exp claim")
+	}
+	return time.Unix(*claims.Exp, 0), nil
+}
+
+// refreshIfExpiring refreshes the app password session of xrpcc when its access token
+// expires within margin, so that the next write does not fail on an expired token.
+// Tokens whose expiry cannot be read are left alone, and a failed refresh is only logged:
+// the current token may still be good enough.
+func refreshIfExpiring(ctx context.Context, cfg Config, xrpcc *xrpc.Client, margin time.Duration) {
+	if margin <= 0 || cfg.OAuth != nil || xrpcc.Auth == nil || xrpcc.Auth.RefreshJwt == "" {
+		return
+	}
+	exp, err := accessTokenExpiry(xrpcc.Auth.AccessJwt)
+	if err != nil {
+		slog.Debug("Cannot read the access token expiry, skipping proactive refresh", "error", err)
+		return
+	}
+	left := time.Until(exp)
+	if left > margin {
+		return
+	}
+	slog.Info("Access token about to expire, refreshing the session", "expiresIn", left.Round(time.Second), "refreshMargin", margin)
+	current := xrpcc.Auth
+	// refreshSession authenticates with the refresh token in place of the access token.
+	xrpcc.Auth = &xrpc.AuthInfo{AccessJwt: current.RefreshJwt, Did: current.Did, Handle: current.Handle}
+	session, err := atproto.ServerRefreshSession(ctx, xrpcc)
+	if err != nil {
+		xrpcc.Auth = current
+		slog.Warn("Failed to refresh the session, continuing with the current access token", ErrorAttrs(err)...)
+		return
+	}
+	xrpcc.Auth = &xrpc.AuthInfo{AccessJwt: session.AccessJwt, RefreshJwt: session.RefreshJwt, Did: session.Did, Handle: session.Handle}
+	if cfg.SessionFile != "" {
+		saveSessionFile(cfg, xrpcc)
+	}
+}
+
+// saveSession writes the session of xrpcc to path, encrypted with key when it is set.
+func saveSession(path, key string, auth *xrpc.AuthInfo) error {
+	session := cachedSession{Did: auth.Did, Handle: auth.Handle, AccessJwt: auth.AccessJwt, RefreshJwt: auth.RefreshJwt}
+	if key == "" {
+		return writeFileAtomic(path, session)
+	}
+	plaintext, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("failed to encode session: %w", err)
+	}
+	gcm, err := sessionCipher(key)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return writeFileAtomic(path, encryptedSession{Nonce: nonce, Ciphertext: gcm.Seal(nil, nonce, plaintext, nil)})
+}
+
+// loadSession reads the session cached in path, decrypting it with key when it is set.
+func loadSession(path, key string) (*cachedSession, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse session file %s: %w", path, err)
+	}
+	_, encrypted := raw["ciphertext"]
+	switch {
+	case encrypted && key == "":
+		return nil, fmt.Errorf("session file %s is encrypted but no session key is set", path)
+	case !encrypted && key != "":
+		return nil, fmt.Errorf("session file %s is not encrypted, ignoring it since a session key is set", path)
+	case encrypted:
+		var enc encryptedSession
+		if err := json.Unmarshal(data, &enc); err != nil {
+			return nil, fmt.Errorf("failed to parse session file %s: %w", path, err)
+		}
+		gcm, err := sessionCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		if len(enc.Nonce) != gcm.NonceSize() {
+			return nil, fmt.Errorf("session file %s has an invalid nonce", path)
+		}
+		if data, err = gcm.Open(nil, enc.Nonce, enc.Ciphertext, nil); err != nil {
+			return nil, fmt.Errorf("failed to decrypt session file %s, wrong session key?", path)
+		}
+	}
+	session := &cachedSession{}
+	if err := json.Unmarshal(data, session); err != nil {
+		return nil, fmt.Errorf("failed to parse session file %s: %w", path, err)
+	}
+	if session.RefreshJwt == "" {
+		return nil, fmt.Errorf("session file %s has no refresh token", path)
+	}
+	return session, nil
+}
+
+// sessionCipher returns the AES-256-GCM cipher keyed by the SHA-256 digest of key.
+func sessionCipher(key string) (cipher.AEAD, error) {
+	digest := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(digest[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,71 @@
+package reposter
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestAccessTokenExpiry(t *testing.T) {
+	exp := time.Date(2026, 10, 16, 10, 0, 0, 0, time.UTC)
+	got, err := accessTokenExpiry(fakeJWT(exp))
+	if err != nil {
+		t.Fatalf("accessTokenExpiry returned error: %v", err)
+	}
+	if !got.Equal(exp) {
+		t.Errorf("accessTokenExpiry = %s, want %s", got, exp)
+	}
+
+	padded := "e30=." + base64.URLEncoding.EncodeToString([]byte(`{"exp":1791885600}`)) + ".sig"
+	if got, err := accessTokenExpiry(padded); err != nil || got.Unix() != 1791885600 {
+		t.Errorf("accessTokenExpiry(padded payload) = %s, %v, want %d", got, err, 1791885600)
+	}
+
+	for name, jwt := range map[string]string{
+		"two parts":   "header.payload",
+		"bad base64":  "e30.!!!.sig",
+		"not JSON":    "e30." + base64.RawURLEncoding.EncodeToString([]byte("exp")) + ".sig",
+		"no exp":      "e30." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"did:plc:me"}`)) + ".sig",
+		"empty token": "",
+	} {
+		if _, err := accessTokenExpiry(jwt); err == nil {
+			t.Errorf("accessTokenExpiry(%s) returned no error", name)
+		}
+	}
+}
+
+func TestRefreshIfExpiring(t *testing.T) {
+	tests := []struct {
+		name    string
+		token   string
+		margin  time.Duration
+		refresh bool
+	}{
+		{"expiring within the margin", fakeJWT(time.Now().Add(time.Minute)), 2 * time.Minute, true},
+		{"already expired", fakeJWT(time.Now().Add(-time.Minute)), 2 * time.Minute, true},
+		{"expiring after the margin", fakeJWT(time.Now().Add(time.Hour)), 2 * time.Minute, false},
+		{"no margin", fakeJWT(time.Now().Add(time.Minute)), 0, false},
+		{"unreadable token", "opaque", 2 * time.Minute, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pds := newFakePDS(t)
+			xrpcc := pds.client()
+			xrpcc.Auth.AccessJwt = tt.token
+
+			refreshIfExpiring(context.Background(), Config{}, xrpcc, tt.margin)
+			refreshed := pds.callCount("com.atproto.server.refreshSession") == 1
+			if refreshed != tt.refresh {
+				t.Fatalf("refreshIfExpiring refreshed the session: %t, want %t", refreshed, tt.refresh)
+			}
+			want := tt.token
+			if tt.refresh {
+				want = pds.AccessJwt
+			}
+			if xrpcc.Auth.AccessJwt != want || xrpcc.Auth.RefreshJwt != "refresh" || xrpcc.Auth.Did != testDID {
+				t.Errorf("refreshIfExpiring left the session %+v, want access token %q", xrpcc.Auth, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package reposter
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// Simulate performs the filter and selection phases of Plan against a feed written by
+// DumpFeed to path instead of a live feed, without authenticating or any other network
+// access, so that a selection can be reproduced from a dump attached to a bug report.
+// Only the settings about selection apply: checks needing the network or the state file,
+// such as SkipEngaged, SkipOwn, the labeler, the start boundary and the daily cap, are skipped.
+// The max post age is measured from now, not from when the feed was dumped.
+// The result counts the writes the plan holds and the selection funnel.
+func Simulate(cfg Config, path string) ([]PostAction, Result, error) {
+	var result Result
+	if cfg.Randomize && (cfg.Order != "" || cfg.Pick != "") {
+		return nil, result, fmt.Errorf("randomize cannot be combined with an order or pick strategy")
+	}
+	cfg = cfg.withDefaults()
+	posts, err := LoadFeed(path)
+	if err != nil {
+		return nil, result, err
+	}
+	slog.Info("Simulating selection against a dumped feed, nothing will be fetched or actioned", "path", path, "posts", len(posts))
+	result.Funnel.Collected = len(posts)
+	result.Funnel.PassedAuthor = len(posts)
+	if cfg.Filters.OnlyURIs = uriSet(cfg.OnlyURIs); cfg.Filters.OnlyURIs != nil {
+		seen := make(map[string]bool, len(posts))
+		for _, post := range posts {
+			seen[post.Uri] = true
+		}
+		warnUnseen(cfg.Filters.OnlyURIs, seen)
+	}
+
+	var plan []PostAction
+	opts := ActionOptions{RepostRequiresPriorLike: cfg.RepostRequiresPriorLike}
+	for _, post := range selectPosts(cfg, posts, cfg.Count, &result.Skipped, &result.Funnel) {
+		if len(plan) >= cfg.Count {
+			break
+		}
+		like, repost := pendingWrites(post, cfg.ActionRules.options(post, opts))
+		if !like && !repost {
+			result.Skipped++
+			continue
+		}
+		plan = append(plan, PostAction{Post: post, Like: like, Repost: repost})
+		result.Funnel.Selected++
+		if like {
+			result.Liked++
+		}
+		if repost {
+			result.Reposted++
+		}
+	}
+	return plan, result, nil
+}
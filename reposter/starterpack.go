@@ -0,0 +1,44 @@
+package reposter
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// StarterPackMembers returns the DIDs of the accounts in the starter pack at uri, in list order.
+func StarterPackMembers(ctx context.Context, xrpcc *xrpc.Client, uri string) ([]string, error) {
+	pack, err := bsky.GraphGetStarterPack(ctx, xrpcc, uri)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get starter pack %s: %w", uri, err)
+	}
+	if pack.StarterPack.List == nil {
+		return nil, fmt.Errorf("starter pack %s has no accessible list", uri)
+	}
+
+	var dids []string
+	cursor := ""
+	for {
+		out, err := bsky.GraphGetList(ctx, xrpcc, cursor, 100, pack.StarterPack.List.Uri)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get list %s of starter pack %s: %w", pack.StarterPack.List.Uri, uri, err)
+		}
+		for _, item := range out.Items {
+			if item.Subject != nil {
+				dids = append(dids, item.Subject.Did)
+			}
+		}
+		if out.Cursor == nil || *out.Cursor == "" {
+			break
+		}
+		cursor = *out.Cursor
+	}
+	if len(dids) == 0 {
+		return nil, fmt.Errorf("starter pack %s has no members", uri)
+	}
+	slog.Info("Expanded starter pack", "starterPack", uri, "members", len(dids))
+	return dids, nil
+}
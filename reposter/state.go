@@ -0,0 +1,296 @@
+package reposter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"iter"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+)
+
+// State is the information persisted between runs in the state file.
+type State struct {
+	// Boundary marks the newest post seen when --start-from-latest was first used.
+	// Only posts indexed after it are actioned.
+	Boundary *Boundary `json:"boundary,omitempty"`
+
+	// Actions records the likes and reposts performed, used to enforce the daily cap.
+	Actions []ActionRecord `json:"actions,omitempty"`
+
+	// LastSummaryAt is when the last --post-summary post was created.
+	LastSummaryAt time.Time `json:"lastSummaryAt,omitzero"`
+
+	// TargetActions counts, per weighted target DID, the posts actioned for it.
+	TargetActions map[string]int `json:"targetActions,omitempty"`
+
+	// LastCrash, when set, describes the last run that ended with a panic.
+	LastCrash *Crash `json:"lastCrash,omitempty"`
+
+	// DiffSeen lists the URIs of the eligible posts already reported by Diff runs, newest last.
+	DiffSeen []string `json:"diffSeen,omitempty"`
+
+	// ScanCursor is where an interrupted full feed scan stopped; cleared once a scan completes.
+	ScanCursor *ScanCursor `json:"scanCursor,omitempty"`
+
+	// FirstRuns records, per target DID or starter pack URI, when the first live run against it
+	// happened; targets missing from it get the first run cap.
+	FirstRuns map[string]time.Time `json:"firstRuns,omitempty"`
+
+	// Quoted records, per URI, when live runs quoted a post. The viewer state does not show
+	// quotes, so these are never pruned: each post is quoted once, however long ago.
+	Quoted map[string]time.Time `json:"quoted,omitempty"`
+}
+
+// recordQuote marks the post at uri as quoted at the given time.
+func (s *State) recordQuote(uri string, at time.Time) {
+	if s.Quoted == nil {
+		s.Quoted = make(map[string]time.Time)
+	}
+	s.Quoted[uri] = at.UTC()
+}
+
+// recordFirstRun marks the first run against each of keys as happened at the given time,
+// keeping the times already recorded.
+func (s *State) recordFirstRun(keys []string, at time.Time) {
+	if s.FirstRuns == nil {
+		s.FirstRuns = make(map[string]time.Time)
+	}
+	for _, key := range keys {
+		if s.FirstRuns[key].IsZero() {
+			s.FirstRuns[key] = at.UTC()
+		}
+	}
+}
+
+// ScanCursor records the progress of a full feed scan so a later run can resume paging from it.
+type ScanCursor struct {
+	Target  string    `json:"target"` // DID whose feed was being scanned
+	Source  string    `json:"source"` // SourceAuthor or SourceLikes
+	Cursor  string    `json:"cursor"` // Cursor of the next page to fetch
+	SavedAt time.Time `json:"savedAt"`
+
+	// Collected lists the posts collected since the scan started that still needed an action,
+	// fetched again by the run resuming the scan so it can select them too.
+	Collected []string `json:"collected,omitempty"`
+}
+
+// ResumableCursor returns the saved cursor of an interrupted scan of target's source feed,
+// or "" when there is none, it belongs to another feed, or it was saved more than staleAfter ago.
+func (s *State) ResumableCursor(target, source string, staleAfter time.Duration, now time.Time) string {
+	c := s.ScanCursor
+	if c == nil || c.Target != target || c.Source != source || c.Cursor == "" {
+		return ""
+	}
+	if staleAfter > 0 && now.Sub(c.SavedAt) > staleAfter {
+		return ""
+	}
+	return c.Cursor
+}
+
+// ActionRecord is a single like or repost performed by a live run.
+type ActionRecord struct {
+	URI    string    `json:"uri"`
+	Author string    `json:"author,omitempty"` // DID of the post's author; empty in records written by older versions
+	Type   string    `json:"type"`             // "like" or "repost"
+	At     time.Time `json:"at"`
+}
+
+// RecordAction appends an action on a post by author performed at the given time.
+func (s *State) RecordAction(uri, author, actionType string, at time.Time) {
+	s.Actions = append(s.Actions, ActionRecord{URI: uri, Author: author, Type: actionType, At: at.UTC()})
+}
+
+// LastReposts returns, for each author with a recorded repost, the time of the latest one.
+func (s *State) LastReposts() map[string]time.Time {
+	last := make(map[string]time.Time)
+	for _, a := range s.Actions {
+		if a.Type == "repost" && a.Author != "" && a.At.After(last[a.Author]) {
+			last[a.Author] = a.At
+		}
+	}
+	return last
+}
+
+// ActionsSince returns how many recorded actions happened after t.
+func (s *State) ActionsSince(t time.Time) int {
+	n := 0
+	for _, a := range s.Actions {
+		if a.At.After(t) {
+			n++
+		}
+	}
+	return n
+}
+
+// PostsActionedSince returns the distinct URIs of the posts with a recorded action after t.
+func (s *State) PostsActionedSince(t time.Time) map[string]bool {
+	uris := make(map[string]bool)
+	for _, a := range s.Actions {
+		if a.At.After(t) {
+			uris[a.URI] = true
+		}
+	}
+	return uris
+}
+
+// Crash is the marker left in the state file by a run that panicked.
+type Crash struct {
+	At    time.Time `json:"at"`
+	Panic string    `json:"panic"` // Recovered panic value
+}
+
+// RecordCrash stores a crash marker for a panic with value at the given time in the state file at path.
+func RecordCrash(path, value string, at time.Time) error {
+	state, _, err := LoadState(path)
+	if err != nil {
+		return err
+	}
+	state.LastCrash = &Crash{At: at.UTC(), Panic: value}
+	return state.Save(path)
+}
+
+// Prune drops the action records, and the crash marker, older than before. Entries without
+// a date, such as the start boundary, first runs and weighted target counters, are kept.
+// It returns the number of entries dropped.
+func (s *State) Prune(before time.Time) int {
+	n := len(s.Actions)
+	s.Actions = slices.DeleteFunc(s.Actions, func(a ActionRecord) bool { return a.At.Before(before) })
+	pruned := n - len(s.Actions)
+	if s.LastCrash != nil && s.LastCrash.At.Before(before) {
+		s.LastCrash = nil
+		pruned++
+	}
+	return pruned
+}
+
+// pruneLogged prunes the entries older than before and logs how many were dropped, if any.
+func (s *State) pruneLogged(before time.Time) int {
+	pruned := s.Prune(before)
+	if pruned > 0 {
+		slog.Info("Pruned old state entries", "pruned", pruned, "before", before.UTC())
+	}
+	return pruned
+}
+
+// CompactState prunes the entries older than retention from the state file at path.
+// It returns the number of entries dropped.
+func CompactState(path string, retention time.Duration) (int, error) {
+	state, found, err := LoadState(path)
+	if err != nil {
+		return 0, err
+	}
+	if !found {
+		return 0, fmt.Errorf("state file %s does not exist", path)
+	}
+	pruned := state.pruneLogged(time.Now().Add(-retention))
+	if err := state.Save(path); err != nil {
+		return 0, err
+	}
+	return pruned, nil
+}
+
+// maxDiffSeen bounds State.DiffSeen; the oldest entries are dropped first.
+const maxDiffSeen = 1000
+
+// newSinceLastDiff yields the posts of seq that were neither actioned nor reported by an
+// earlier Diff run, and records every post it consumes as reported.
+func (s *State) newSinceLastDiff(seq iter.Seq[*bsky.FeedDefs_PostView]) iter.Seq[*bsky.FeedDefs_PostView] {
+	known := s.PostsActionedSince(time.Time{})
+	for _, uri := range s.DiffSeen {
+		known[uri] = true
+	}
+	return func(yield func(*bsky.FeedDefs_PostView) bool) {
+		for post := range seq {
+			if known[post.Uri] {
+				slog.Debug("Skipping eligible post already reported by a previous diff", "postUri", post.Uri)
+				continue
+			}
+			known[post.Uri] = true
+			s.DiffSeen = append(s.DiffSeen, post.Uri)
+			if len(s.DiffSeen) > maxDiffSeen {
+				s.DiffSeen = slices.Clone(s.DiffSeen[len(s.DiffSeen)-maxDiffSeen:])
+			}
+			slog.Info("New eligible post since the last diff", "postUri", post.Uri)
+			if !yield(post) {
+				return
+			}
+		}
+	}
+}
+
+// Boundary identifies a post by URI and the time it was indexed.
+type Boundary struct {
+	URI       string `json:"uri"`
+	IndexedAt string `json:"indexedAt"`
+}
+
+// LoadState reads the state file at path. A missing file yields an empty state and found set to false.
+func LoadState(path string) (state *State, found bool, err error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &State{}, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read state file %s: %w", path, err)
+	}
+	state = &State{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, false, fmt.Errorf("failed to parse state file %s: %w", path, err)
+	}
+	return state, true, nil
+}
+
+// Save atomically writes the state to path, readable only by the current user.
+func (s *State) Save(path string) error {
+	return writeFileAtomic(path, s)
+}
+
+// writeFileAtomic writes v as indented JSON to path through a temporary file, readable only by the current user.
+func writeFileAtomic(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", path, err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file for %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return nil
+}
+
+// firstRunKeys returns the keys of State.FirstRuns identifying the targets of cfg: the weighted
+// target DIDs, the starter pack URI, the search query prefixed with "search:" or the target DID.
+func firstRunKeys(cfg Config) []string {
+	switch {
+	case cfg.Search != "":
+		return []string{"search:" + cfg.Search}
+	case len(cfg.Targets) > 0:
+		keys := make([]string, 0, len(cfg.Targets))
+		for _, t := range cfg.Targets {
+			keys = append(keys, t.DID)
+		}
+		return keys
+	case cfg.StarterPack != "":
+		return []string{cfg.StarterPack}
+	default:
+		return []string{cfg.TargetDID}
+	}
+}
@@ -0,0 +1,58 @@
+package reposter
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// screenTargets returns the targets of dids whose profile has at least minFollowers followers
+// and was created at least minAccountAge ago, logging every target skipped and why.
+// Targets whose profile cannot be fetched are skipped too. Each profile is fetched once.
+func screenTargets(ctx context.Context, xrpcc *xrpc.Client, dids []string, minFollowers int64, minAccountAge time.Duration) []string {
+	if minFollowers <= 0 && minAccountAge <= 0 {
+		return dids
+	}
+	profiles := make(map[string]*bsky.ActorDefs_ProfileViewDetailed)
+	var kept []string
+	for _, did := range dids {
+		profile, ok := profiles[did]
+		if !ok {
+			var err error
+			profile, err = bsky.ActorGetProfile(ctx, xrpcc, did)
+			if err != nil {
+				slog.Warn("Skipping target, could not fetch their profile", append([]any{"targetUserDID", did}, ErrorAttrs(err)...)...)
+				continue
+			}
+			profiles[did] = profile
+		}
+		if followers := countOrZero(profile.FollowersCount); followers < minFollowers {
+			slog.Info("Skipping target with too few followers",
+				"targetUserDID", did,
+				"followers", followers,
+				"minFollowers", minFollowers,
+			)
+			continue
+		}
+		if minAccountAge > 0 {
+			var created time.Time
+			var err error
+			if profile.CreatedAt != nil {
+				created, err = ParseTimestamp(*profile.CreatedAt)
+			}
+			if profile.CreatedAt == nil || err != nil || time.Since(created) < minAccountAge {
+				slog.Info("Skipping target, account too recent or of unknown age",
+					"targetUserDID", did,
+					"createdAt", profile.CreatedAt,
+					"minAccountAge", minAccountAge,
+				)
+				continue
+			}
+		}
+		kept = append(kept, did)
+	}
+	return kept
+}
@@ -0,0 +1,28 @@
+package reposter
+
+import (
+	"fmt"
+	"time"
+)
+
+// TimestampFormat is the layout of the timestamps written in records: RFC 3339 in UTC with
+// millisecond precision, as used by the official Bluesky clients.
+const TimestampFormat = "2006-01-02T15:04:05.000Z"
+
+// FormatTimestamp formats t for a record's createdAt field.
+func FormatTimestamp(t time.Time) string {
+	return t.UTC().Format(TimestampFormat)
+}
+
+// ParseTimestamp parses an atproto datetime, with or without fractional seconds.
+// Timestamps lacking a time zone, which some clients write, are taken as UTC.
+func ParseTimestamp(s string) (time.Time, error) {
+	// time.RFC3339 also accepts fractional seconds when parsing.
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02T15:04:05", s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %q", s)
+}
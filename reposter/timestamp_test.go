@@ -0,0 +1,65 @@
+package reposter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{"2026-10-16T10:04:05.123Z", time.Date(2026, 10, 16, 10, 4, 5, 123e6, time.UTC)},
+		{"2026-10-16T10:04:05Z", time.Date(2026, 10, 16, 10, 4, 5, 0, time.UTC)},
+		{"2026-10-16T12:04:05+02:00", time.Date(2026, 10, 16, 10, 4, 5, 0, time.UTC)},
+		{"2026-10-16T10:04:05.123456789Z", time.Date(2026, 10, 16, 10, 4, 5, 123456789, time.UTC)},
+		{"2026-10-16T10:04:05", time.Date(2026, 10, 16, 10, 4, 5, 0, time.UTC)},
+		{"2026-10-16T10:04:05.123", time.Date(2026, 10, 16, 10, 4, 5, 123e6, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := ParseTimestamp(tt.in)
+		if err != nil {
+			t.Errorf("ParseTimestamp(%q) returned error: %v", tt.in, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("ParseTimestamp(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseTimestampInvalid(t *testing.T) {
+	for _, in := range []string{"", "2026-10-16", "16/10/2026 10:04", "2026-10-16T25:00:00Z"} {
+		if _, err := ParseTimestamp(in); err == nil {
+			t.Errorf("ParseTimestamp(%q) returned no error", in)
+		}
+	}
+}
+
+func TestFormatTimestampRoundTrip(t *testing.T) {
+	tests := []struct {
+		in   time.Time
+		want string
+	}{
+		{time.Date(2026, 10, 16, 10, 4, 5, 123e6, time.UTC), "2026-10-16T10:04:05.123Z"},
+		{time.Date(2026, 10, 16, 10, 4, 5, 0, time.UTC), "2026-10-16T10:04:05.000Z"},
+		{time.Date(2026, 10, 16, 12, 4, 5, 0, time.FixedZone("CEST", 2*60*60)), "2026-10-16T10:04:05.000Z"},
+		// Precision below the millisecond is truncated.
+		{time.Date(2026, 10, 16, 10, 4, 5, 123456789, time.UTC), "2026-10-16T10:04:05.123Z"},
+	}
+	for _, tt := range tests {
+		s := FormatTimestamp(tt.in)
+		if s != tt.want {
+			t.Errorf("FormatTimestamp(%s) = %q, want %q", tt.in, s, tt.want)
+		}
+		got, err := ParseTimestamp(s)
+		if err != nil {
+			t.Errorf("ParseTimestamp(%q) returned error: %v", s, err)
+			continue
+		}
+		if want := tt.in.Truncate(time.Millisecond); !got.Equal(want) {
+			t.Errorf("ParseTimestamp(FormatTimestamp(%s)) = %s, want %s", tt.in, got, want)
+		}
+	}
+}
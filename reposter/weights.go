@@ -0,0 +1,144 @@
+package reposter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Target actions, the values of WeightedTarget.Actions.
+const (
+	TargetActionLike   = "like"
+	TargetActionRepost = "repost"
+	TargetActionQuote  = "quote" // A quote post with the target's QuoteText, in place of the repost
+)
+
+// WeightedTarget is a target account and its share of the actions across runs.
+// The remaining fields are the target's own policy; zero values keep the global settings.
+type WeightedTarget struct {
+	DID    string
+	Weight int
+
+	Actions   []string // Actions taken on the target's posts, among the TargetAction* values; empty means like and repost
+	Count     int      // When positive, posts actioned for this target per run, instead of its weighted share of Count
+	Filter    string   // Author feed filter of the target, one of the AuthorFilter* values
+	QuoteText string   // Text of the quote posts of TargetActionQuote
+}
+
+// targetPolicy is the JSON form of a WeightedTarget, as written in config files.
+type targetPolicy struct {
+	DID       string   `json:"did"`
+	Weight    int      `json:"weight"`
+	Actions   []string `json:"actions"`
+	Count     int      `json:"count"`
+	Filter    string   `json:"filter"`
+	QuoteText string   `json:"quote-text"`
+}
+
+// ParseWeightedTarget parses a target given as "did:..." (weight 1) or "did:...=N", or as a
+// JSON object with the keys did, weight, actions, count, filter and quote-text, carrying the
+// target's own policy. Unknown keys are rejected.
+func ParseWeightedTarget(s string) (WeightedTarget, error) {
+	if strings.HasPrefix(strings.TrimSpace(s), "{") {
+		return parseTargetPolicy(s)
+	}
+	did, weight, hasWeight := strings.Cut(s, "=")
+	t := WeightedTarget{DID: did, Weight: 1}
+	if !strings.HasPrefix(did, "did:") {
+		return t, fmt.Errorf("invalid target %q, expected did:... or did:...=weight", s)
+	}
+	if hasWeight {
+		n, err := strconv.Atoi(weight)
+		if err != nil || n < 1 {
+			return t, fmt.Errorf("invalid weight in target %q, expected a positive integer", s)
+		}
+		t.Weight = n
+	}
+	return t, nil
+}
+
+// parseTargetPolicy parses the JSON form of a target.
+func parseTargetPolicy(s string) (WeightedTarget, error) {
+	dec := json.NewDecoder(bytes.NewReader([]byte(s)))
+	dec.DisallowUnknownFields()
+	p := targetPolicy{Weight: 1}
+	if err := dec.Decode(&p); err != nil {
+		return WeightedTarget{}, fmt.Errorf("invalid target %s: %w", s, err)
+	}
+	t := WeightedTarget{DID: p.DID, Weight: p.Weight, Actions: p.Actions, Count: p.Count, Filter: p.Filter, QuoteText: p.QuoteText}
+	if err := t.validate(); err != nil {
+		return t, err
+	}
+	return t, nil
+}
+
+// validate checks the DID, weight and policy of the target.
+func (t WeightedTarget) validate() error {
+	if !strings.HasPrefix(t.DID, "did:") || t.Weight < 1 {
+		return fmt.Errorf("invalid weighted target %s=%d, expected a DID and a positive weight", t.DID, t.Weight)
+	}
+	for _, a := range t.Actions {
+		if a != TargetActionLike && a != TargetActionRepost && a != TargetActionQuote {
+			return fmt.Errorf("invalid action %q of target %s, expected %s, %s or %s", a, t.DID, TargetActionLike, TargetActionRepost, TargetActionQuote)
+		}
+	}
+	if t.Actions != nil && len(t.Actions) == 0 {
+		return fmt.Errorf("target %s has no actions", t.DID)
+	}
+	quotes := slices.Contains(t.Actions, TargetActionQuote)
+	if quotes && slices.Contains(t.Actions, TargetActionRepost) {
+		return fmt.Errorf("target %s cannot both repost and quote", t.DID)
+	}
+	if quotes != (t.QuoteText != "") {
+		return fmt.Errorf("target %s must set a quote text exactly when quoting", t.DID)
+	}
+	if t.Count < 0 {
+		return fmt.Errorf("invalid count %d of target %s, must not be negative", t.Count, t.DID)
+	}
+	if t.Filter != "" && !slices.Contains(authorFilters, t.Filter) {
+		return fmt.Errorf("invalid filter %q of target %s, expected one of %s", t.Filter, t.DID, strings.Join(authorFilters, ", "))
+	}
+	return nil
+}
+
+// options returns the action options for the target's posts, starting from opts.
+func (t WeightedTarget) options(opts ActionOptions) ActionOptions {
+	if len(t.Actions) == 0 {
+		return opts
+	}
+	opts.NoLike = !slices.Contains(t.Actions, TargetActionLike)
+	opts.QuoteText = ""
+	if slices.Contains(t.Actions, TargetActionQuote) {
+		opts.QuoteText = t.QuoteText
+	} else if !slices.Contains(t.Actions, TargetActionRepost) {
+		opts.LikeOnly = true
+	}
+	return opts
+}
+
+// allocateByWeight splits n actions among targets so that, together with the actions
+// already done for each of them, the totals stay as close as possible to the weights.
+// Each action goes in turn to the target furthest below its weighted share.
+func allocateByWeight(targets []WeightedTarget, done map[string]int, n int) map[string]int {
+	totalWeight, totalDone := 0, 0
+	for _, t := range targets {
+		totalWeight += t.Weight
+		totalDone += done[t.DID]
+	}
+	alloc := make(map[string]int, len(targets))
+	for k := range n {
+		best, bestDeficit := "", 0.0
+		for _, t := range targets {
+			share := float64(totalDone+k+1) * float64(t.Weight) / float64(totalWeight)
+			deficit := share - float64(done[t.DID]+alloc[t.DID])
+			if best == "" || deficit > bestDeficit {
+				best, bestDeficit = t.DID, deficit
+			}
+		}
+		alloc[best]++
+	}
+	return alloc
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build metadata, set at build time with
+// -ldflags "-X main.version=... -X main.commit=... -X main.date=...".
+var (
+	version = "dev"
+	commit  = ""
+	date    = ""
+)
+
+// buildInfo returns the version, commit and build date, falling back to the
+// information embedded by the Go toolchain for values not set via ldflags.
+func buildInfo() (v, c, d string) {
+	v, c, d = version, commit, date
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return v, c, d
+	}
+	if v == "dev" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		v = info.Main.Version
+	}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			if c == "" {
+				c = setting.Value
+			}
+		case "vcs.time":
+			if d == "" {
+				d = setting.Value
+			}
+		}
+	}
+	return v, c, d
+}
+
+// printVersion writes the build information to stdout.
+func printVersion() {
+	v, c, d := buildInfo()
+	if c == "" {
+		c = "unknown"
+	}
+	if d == "" {
+		d = "unknown"
+	}
+	fmt.Printf("bs-reposter-liker %s\ncommit: %s\nbuilt: %s\ngo: %s\n", v, c, d, runtime.Version())
+}